@@ -0,0 +1,17 @@
+// Package azidentityextarm helps creating ARM clients authenticated by an azidentityext.DefaultAzureCredential.
+// It is a separate package so that the azcore/arm dependency isn't pulled into azidentityext.
+package azidentityextarm
+
+import (
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/magodo/azidentityext"
+)
+
+// ClientOptions returns the options for creating an ARM client with cred, populated with the cloud cred is
+// configured for, so that the ARM client and the credential target the same cloud. cred is typically a
+// *azidentityext.DefaultAzureCredential.
+func ClientOptions(cred azidentityext.Credential) *arm.ClientOptions {
+	options := &arm.ClientOptions{}
+	options.Cloud = cred.Cloud()
+	return options
+}
@@ -0,0 +1,133 @@
+// Package azidentityextprom exposes the metrics of an azidentityext.DefaultAzureCredential to Prometheus.
+// It is a separate module so that the Prometheus dependency isn't pulled into azidentityext.
+package azidentityextprom
+
+import (
+	"errors"
+	"io/fs"
+	"time"
+
+	"github.com/magodo/azidentityext"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "azidentityext"
+
+// Collector is both a prometheus.Collector and an azidentityext.MetricsSink: register it with a prometheus.Registerer
+// and pass it as the DefaultAzureCredentialOptions.Metrics option. It exposes:
+//
+//   - azidentityext_credential_attempts_total, the token acquisitions attempted, by credential
+//   - azidentityext_credential_successes_total, the tokens acquired, by credential
+//   - azidentityext_credential_failures_total, the failed token acquisitions, by credential
+//   - azidentityext_credential_duration_seconds, the duration of the token acquisitions, by credential and result
+//     ("success" or "failure")
+//   - azidentityext_cache_hits_total, the tokens served by the token cache
+//   - azidentityext_credential_construction_failures_total, the credentials that failed to be built, by credential
+//     and error category ("file_not_found", "permission_denied" or "configuration")
+type Collector struct {
+	azidentityext.NopMetricsSink
+
+	attempts  *prometheus.CounterVec
+	successes *prometheus.CounterVec
+	failures  *prometheus.CounterVec
+	durations *prometheus.HistogramVec
+	cacheHits prometheus.Counter
+
+	constructionFailures *prometheus.CounterVec
+}
+
+// NewCollector creates a Collector.
+func NewCollector() *Collector {
+	return &Collector{
+		attempts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "credential_attempts_total",
+			Help:      "The token acquisitions attempted by the credentials of the chain.",
+		}, []string{"credential"}),
+		successes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "credential_successes_total",
+			Help:      "The tokens acquired by the credentials of the chain.",
+		}, []string{"credential"}),
+		failures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "credential_failures_total",
+			Help:      "The failed token acquisitions of the credentials of the chain.",
+		}, []string{"credential"}),
+		durations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "credential_duration_seconds",
+			Help:      "The duration of the token acquisitions of the credentials of the chain.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"credential", "result"}),
+		cacheHits: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cache_hits_total",
+			Help:      "The tokens served by the token cache.",
+		}),
+		constructionFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "credential_construction_failures_total",
+			Help:      "The credentials of the chain that failed to be built.",
+		}, []string{"credential", "category"}),
+	}
+}
+
+func (c *Collector) Attempt(name string) {
+	c.attempts.WithLabelValues(name).Inc()
+}
+
+func (c *Collector) Success(name string, d time.Duration) {
+	c.successes.WithLabelValues(name).Inc()
+	c.durations.WithLabelValues(name, "success").Observe(d.Seconds())
+}
+
+func (c *Collector) Failure(name string, d time.Duration, _ error) {
+	c.failures.WithLabelValues(name).Inc()
+	c.durations.WithLabelValues(name, "failure").Observe(d.Seconds())
+}
+
+// CacheHit counts the cache hits without their key, which would make the cardinality of the metric unbounded.
+func (c *Collector) CacheHit(string) {
+	c.cacheHits.Inc()
+}
+
+func (c *Collector) ConstructionFailure(name string, err error) {
+	c.constructionFailures.WithLabelValues(name, constructionErrorCategory(err)).Inc()
+}
+
+// constructionErrorCategory returns the category of an error building a credential, keeping the cardinality of
+// the label bounded.
+func constructionErrorCategory(err error) string {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return "file_not_found"
+	case errors.Is(err, fs.ErrPermission):
+		return "permission_denied"
+	default:
+		return "configuration"
+	}
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	c.attempts.Describe(ch)
+	c.successes.Describe(ch)
+	c.failures.Describe(ch)
+	c.durations.Describe(ch)
+	c.cacheHits.Describe(ch)
+	c.constructionFailures.Describe(ch)
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.attempts.Collect(ch)
+	c.successes.Collect(ch)
+	c.failures.Collect(ch)
+	c.durations.Collect(ch)
+	c.cacheHits.Collect(ch)
+	c.constructionFailures.Collect(ch)
+}
+
+var (
+	_ azidentityext.MetricsSink = (*Collector)(nil)
+	_ prometheus.Collector      = (*Collector)(nil)
+)
@@ -0,0 +1,192 @@
+// Package azidentityexttest helps testing the credentials of an azidentityext.DefaultAzureCredential against
+// recorded HTTP sessions, e.g. the token exchanges with Azure AD or IMDS, so that the tests are deterministic and
+// need no network. A Session is a policy.Transporter, to be set as the
+// DefaultAzureCredentialOptions.ClientOptions.Transport.
+package azidentityexttest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// Interaction is a request of a recorded session and the response it was served.
+type Interaction struct {
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+}
+
+// Request identifies the requests an Interaction is served for.
+type Request struct {
+	// Method is the HTTP method, defaulting to GET.
+	Method string `json:"method"`
+	// URL is the absolute URL of the request. The query parameters it lists must be in the request with the same
+	// values, the others being ignored, as they often vary, e.g. the client-request-id of Azure AD.
+	URL string `json:"url"`
+}
+
+// Response is the response of an Interaction.
+type Response struct {
+	// Status is the HTTP status code, defaulting to 200.
+	Status int `json:"status"`
+	// Headers are the headers of the response.
+	Headers map[string]string `json:"headers"`
+	// Body is the body of the response. A body that is a JSON object or array, rather than a string, is served as
+	// is, e.g. {"access_token": "..."}.
+	Body json.RawMessage `json:"body"`
+}
+
+// Session replays the Interactions of a recorded session. Each request is served the response of the first
+// Interaction not served yet whose Request matches it, in the recording order, so that a session can record
+// several exchanges of the same endpoint, e.g. a failure followed by a success. A request matching none fails
+// with an error, as a network error would. It is safe for concurrent use.
+type Session struct {
+	mu           sync.Mutex
+	interactions []Interaction
+	served       []bool
+}
+
+// NewSession creates a Session replaying interactions.
+func NewSession(interactions []Interaction) (*Session, error) {
+	for i, in := range interactions {
+		if u, err := url.Parse(in.Request.URL); err != nil || !u.IsAbs() {
+			return nil, fmt.Errorf("interaction %d: the request URL %q must be absolute", i, in.Request.URL)
+		}
+	}
+	return &Session{interactions: interactions, served: make([]bool, len(interactions))}, nil
+}
+
+// LoadSession creates a Session replaying the session recorded in the JSON file at path, whose format is:
+//
+//	{
+//	  "interactions": [
+//	    {
+//	      "request": {"method": "POST", "url": "https://login.microsoftonline.com/<tenant>/oauth2/v2.0/token"},
+//	      "response": {
+//	        "status": 200,
+//	        "headers": {"Content-Type": "application/json"},
+//	        "body": {"token_type": "Bearer", "expires_in": 3599, "access_token": "<token>"}
+//	      }
+//	    }
+//	  ]
+//	}
+//
+// The fields are those of Interaction. Recorded sessions must not contain real secrets or tokens, replace them by
+// placeholders.
+//
+// With Azure AD, the credentials also request the instance and tenant discovery documents before the token,
+// unless instance discovery is disabled, i.e. "GET https://login.microsoftonline.com/common/discovery/instance"
+// and "GET https://login.microsoftonline.com/<tenant>/v2.0/.well-known/openid-configuration", which the session
+// must record too.
+func LoadSession(path string) (*Session, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var recorded struct {
+		Interactions []Interaction `json:"interactions"`
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&recorded); err != nil {
+		return nil, fmt.Errorf("invalid recorded session %s: %v", path, err)
+	}
+	s, err := NewSession(recorded.Interactions)
+	if err != nil {
+		return nil, fmt.Errorf("invalid recorded session %s: %v", path, err)
+	}
+	return s, nil
+}
+
+// Do serves req the response of its Interaction.
+func (s *Session) Do(req *http.Request) (*http.Response, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, in := range s.interactions {
+		if s.served[i] || !matches(in.Request, req) {
+			continue
+		}
+		s.served[i] = true
+		return newResponse(req, in.Response)
+	}
+	return nil, fmt.Errorf("no recorded interaction for %s %s", req.Method, req.URL.Redacted())
+}
+
+// Unserved returns the Interactions not served yet, e.g. to check at the end of a test that the session was
+// entirely replayed.
+func (s *Session) Unserved() []Interaction {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var unserved []Interaction
+	for i, in := range s.interactions {
+		if !s.served[i] {
+			unserved = append(unserved, in)
+		}
+	}
+	return unserved
+}
+
+func matches(recorded Request, req *http.Request) bool {
+	method := recorded.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	if !strings.EqualFold(method, req.Method) {
+		return false
+	}
+	u, err := url.Parse(recorded.URL)
+	if err != nil {
+		return false
+	}
+	if !strings.EqualFold(u.Scheme, req.URL.Scheme) || !strings.EqualFold(u.Host, req.URL.Host) || strings.TrimSuffix(u.Path, "/") != strings.TrimSuffix(req.URL.Path, "/") {
+		return false
+	}
+	query := req.URL.Query()
+	for k, v := range u.Query() {
+		if !query.Has(k) || strings.Join(query[k], ",") != strings.Join(v, ",") {
+			return false
+		}
+	}
+	return true
+}
+
+func newResponse(req *http.Request, recorded Response) (*http.Response, error) {
+	status := recorded.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	body := []byte(recorded.Body)
+	var s string
+	if len(body) != 0 && body[0] == '"' {
+		if err := json.Unmarshal(body, &s); err != nil {
+			return nil, errors.New("invalid recorded response body: " + err.Error())
+		}
+		body = []byte(s)
+	}
+	header := http.Header{}
+	for k, v := range recorded.Headers {
+		header.Set(k, v)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+var _ policy.Transporter = (*Session)(nil)
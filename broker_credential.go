@@ -0,0 +1,93 @@
+package azidentityext
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+)
+
+const credNameBroker = "BrokerCredential"
+
+// defaultBrokerSecretHeader is the header carrying the broker secret when BrokerCredentialOptions.SecretHeader is empty.
+const defaultBrokerSecretHeader = "X-Broker-Secret"
+
+// BrokerCredentialOptions contains optional parameters for BrokerCredential.
+type BrokerCredentialOptions struct {
+	azcore.ClientOptions
+
+	// Secret, if set, is sent in the SecretHeader of each request to authenticate to the broker.
+	Secret string
+	// SecretHeader is the name of the header carrying the Secret. Defaults to "X-Broker-Secret".
+	SecretHeader string
+}
+
+// BrokerCredential requests tokens from a local secrets broker, which issues tokens for the identity of the host.
+//
+// The broker is sent a POST request with a JSON body of the form {"scopes": ["<scope>", ...]}, and is expected
+// to respond with status 200 and a JSON body of the form {"token": "<token>", "expiresOn": "<RFC 3339 time>"}.
+type BrokerCredential struct {
+	endpoint     string
+	secret       string
+	secretHeader string
+	pipeline     runtime.Pipeline
+}
+
+// NewBrokerCredential creates a BrokerCredential for the broker at endpoint. Pass nil for options to accept defaults.
+func NewBrokerCredential(endpoint string, options *BrokerCredentialOptions) (*BrokerCredential, error) {
+	if endpoint == "" {
+		return nil, errors.New("endpoint is required")
+	}
+	if options == nil {
+		options = &BrokerCredentialOptions{}
+	}
+	secretHeader := options.SecretHeader
+	if secretHeader == "" {
+		secretHeader = defaultBrokerSecretHeader
+	}
+	return &BrokerCredential{
+		endpoint:     endpoint,
+		secret:       options.Secret,
+		secretHeader: secretHeader,
+		pipeline:     runtime.NewPipeline(component, version, runtime.PipelineOptions{}, &options.ClientOptions),
+	}, nil
+}
+
+// GetToken requests an access token from the broker. This method is called automatically by Azure SDK clients.
+func (c *BrokerCredential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	req, err := runtime.NewRequest(ctx, http.MethodPost, c.endpoint)
+	if err != nil {
+		return azcore.AccessToken{}, fmt.Errorf("%s: %v", credNameBroker, err)
+	}
+	if c.secret != "" {
+		req.Raw().Header.Set(c.secretHeader, c.secret)
+	}
+	if err := runtime.MarshalAsJSON(req, brokerRequest{Scopes: opts.Scopes}); err != nil {
+		return azcore.AccessToken{}, fmt.Errorf("%s: %v", credNameBroker, err)
+	}
+	resp, err := c.pipeline.Do(req)
+	if err != nil {
+		return azcore.AccessToken{}, fmt.Errorf("%s: %w", credNameBroker, err)
+	}
+	if !runtime.HasStatusCode(resp, http.StatusOK) {
+		return azcore.AccessToken{}, fmt.Errorf("%s: %w", credNameBroker, &redactedError{err: runtime.NewResponseError(resp)})
+	}
+	var tk azcore.AccessToken
+	if err := runtime.UnmarshalAsJSON(resp, &tk); err != nil {
+		return azcore.AccessToken{}, fmt.Errorf("%s: malformed response: %v", credNameBroker, err)
+	}
+	if tk.Token == "" || tk.ExpiresOn.IsZero() {
+		return azcore.AccessToken{}, fmt.Errorf(`%s: malformed response: "token" and "expiresOn" are required`, credNameBroker)
+	}
+	return tk, nil
+}
+
+type brokerRequest struct {
+	Scopes []string `json:"scopes"`
+}
+
+var _ azcore.TokenCredential = (*BrokerCredential)(nil)
@@ -0,0 +1,92 @@
+package azidentityext
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// defaultCLICommandTimeout is the default DefaultAzureCredentialOptions.CLICommandTimeout, the timeout azidentity
+// applies to the CLI when the context has no deadline.
+const defaultCLICommandTimeout = 10 * time.Second
+
+// ErrAzureCLITokenExpired is wrapped by the error of the AzureCLICredential when the login of the Azure CLI expired,
+// e.g. its refresh token expired due to inactivity, as opposed to the CLI not being logged in at all. Running az login
+// again fixes it.
+var ErrAzureCLITokenExpired = errors.New("the Azure CLI login expired, run az login again")
+
+// cliExpiredLoginFragments identify the CLI error output about an expired login, in lower case.
+var cliExpiredLoginFragments = []string{
+	"aadsts700082", // the refresh token has expired due to inactivity
+	"aadsts70043",  // the refresh token has expired or is invalid due to sign-in frequency checks
+	"aadsts50173",  // the provided grant has expired due to it being revoked
+	"refresh token has expired",
+	"token is expired",
+}
+
+// cliCredential wraps a credential that shells out to a CLI. It bounds its acquisitions by a timeout, reporting the
+// timeout as the credential being unavailable, so that the chain goes on with its next credential, and classifies
+// its errors.
+type cliCredential struct {
+	kind    CredentialKind
+	cred    azcore.TokenCredential
+	timeout time.Duration
+}
+
+func (c *cliCredential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	tctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	type result struct {
+		tk  azcore.AccessToken
+		err error
+	}
+	// the call may not honor the context while waiting for a concurrent one to complete
+	ch := make(chan result, 1)
+	go func() {
+		tk, err := c.cred.GetToken(tctx, opts)
+		ch <- result{tk, err}
+	}()
+	select {
+	case r := <-ch:
+		if r.err != nil && ctx.Err() == nil && tctx.Err() != nil {
+			return r.tk, c.timedOut()
+		}
+		return r.tk, c.classify(r.err)
+	case <-tctx.Done():
+		if ctx.Err() != nil {
+			return azcore.AccessToken{}, ctx.Err()
+		}
+		return azcore.AccessToken{}, c.timedOut()
+	}
+}
+
+func (c *cliCredential) timedOut() error {
+	return newCredentialUnavailableError(string(c.kind), fmt.Sprintf("the CLI didn't complete within %s", c.timeout))
+}
+
+// classify wraps ErrAzureCLITokenExpired and err in an error about an expired login, keeping it unavailable, as
+// azidentity reports it, so that the chain still goes on with its next credential.
+func (c *cliCredential) classify(err error) error {
+	if err == nil {
+		return nil
+	}
+	msg := strings.ToLower(err.Error())
+	for _, f := range cliExpiredLoginFragments {
+		if strings.Contains(msg, f) {
+			// the error of azidentity is already prefixed by the kind
+			detail := strings.TrimPrefix(err.Error(), string(c.kind)+": ")
+			return &credentialUnavailableError{
+				msg: fmt.Sprintf("%s: %v: %s", c.kind, ErrAzureCLITokenExpired, detail),
+				err: fmt.Errorf("%w: %w", ErrAzureCLITokenExpired, err),
+			}
+		}
+	}
+	return err
+}
+
+var _ azcore.TokenCredential = (*cliCredential)(nil)
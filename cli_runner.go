@@ -0,0 +1,85 @@
+package azidentityext
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// CLIRunner runs a CLI with args, args[0] being the CLI, e.g. "az", and returns its standard output. When the CLI
+// fails, the error should include its standard error, which the credential shows and classifies, e.g. to report
+// that the CLI isn't logged in.
+type CLIRunner func(ctx context.Context, args []string) ([]byte, error)
+
+// runnerCLICredential acquires tokens from the Azure CLI through a CLIRunner, with the same command and tenant
+// rules as azidentity.AzureCLICredential, which can't be given a runner.
+type runnerCLICredential struct {
+	run      CLIRunner
+	tenantID string
+	// allowedTenants are the additionally allowed tenants, "*" allowing any
+	allowedTenants []string
+}
+
+func (c *runnerCLICredential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	name := string(CredentialKindAzureCLI)
+	if len(opts.Scopes) != 1 {
+		return azcore.AccessToken{}, fmt.Errorf("%s: GetToken() requires exactly one scope", name)
+	}
+	tenantID := c.tenantID
+	if t := opts.TenantID; t != "" && t != c.tenantID {
+		if !slices.Contains(c.allowedTenants, "*") && !slices.Contains(c.allowedTenants, t) {
+			return azcore.AccessToken{}, fmt.Errorf("%s isn't configured to acquire tokens for tenant %q, add it to AdditionallyAllowedTenants", name, t)
+		}
+		tenantID = t
+	}
+	args := []string{"az", "account", "get-access-token", "-o", "json", "--resource", strings.TrimSuffix(opts.Scopes[0], defaultScopeSuffix)}
+	if tenantID != "" {
+		args = append(args, "--tenant", tenantID)
+	}
+	out, err := c.run(ctx, args)
+	if err != nil {
+		if ctx.Err() != nil {
+			return azcore.AccessToken{}, ctx.Err()
+		}
+		if errors.Is(err, exec.ErrNotFound) {
+			return azcore.AccessToken{}, newCredentialUnavailableError(name, "Azure CLI not found on path")
+		}
+		// as in azidentity, any CLI failure makes the credential unavailable within the chain
+		return azcore.AccessToken{}, &credentialUnavailableError{msg: name + ": " + err.Error(), err: err}
+	}
+	return parseCLIToken(out)
+}
+
+// parseCLIToken parses the output of az account get-access-token, whose expires_on is only set by recent
+// versions of the CLI, expiresOn being in local time.
+func parseCLIToken(out []byte) (azcore.AccessToken, error) {
+	var v struct {
+		AccessToken string      `json:"accessToken"`
+		ExpiresOn   string      `json:"expiresOn"`
+		ExpiresOnTS json.Number `json:"expires_on"`
+	}
+	if err := json.Unmarshal(out, &v); err != nil {
+		return azcore.AccessToken{}, fmt.Errorf("%s: malformed output: %v", CredentialKindAzureCLI, err)
+	}
+	if v.ExpiresOnTS != "" {
+		if ts, err := strconv.ParseInt(v.ExpiresOnTS.String(), 10, 64); err == nil {
+			return azcore.AccessToken{Token: v.AccessToken, ExpiresOn: time.Unix(ts, 0).UTC()}, nil
+		}
+	}
+	exp, err := time.ParseInLocation("2006-01-02 15:04:05.999999", v.ExpiresOn, time.Local)
+	if err != nil {
+		return azcore.AccessToken{}, fmt.Errorf("%s: malformed output: unexpected expiresOn %q", CredentialKindAzureCLI, v.ExpiresOn)
+	}
+	return azcore.AccessToken{Token: v.AccessToken, ExpiresOn: exp.UTC()}, nil
+}
+
+var _ azcore.TokenCredential = (*runnerCLICredential)(nil)
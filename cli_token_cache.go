@@ -0,0 +1,56 @@
+package azidentityext
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// cliCachedToken is a token cached by cliCachingCredential, with the time it may be reused until.
+type cliCachedToken struct {
+	tk    azcore.AccessToken
+	until time.Time
+}
+
+// cliCachingCredential wraps a credential that shells out to a CLI, reusing its tokens for the same scopes and
+// tenant for up to ttl, so that repeated requests don't invoke the CLI each time. A token is never reused past
+// its expiry minus skew, nor for a claims challenge, whose token replaces the cached one when acquired.
+type cliCachingCredential struct {
+	cred azcore.TokenCredential
+	ttl  time.Duration
+	skew time.Duration
+	now  func() time.Time
+
+	mu      sync.Mutex
+	entries map[tokenCacheKey]cliCachedToken
+}
+
+func newCLICachingCredential(cred azcore.TokenCredential, ttl, skew time.Duration, now func() time.Time) *cliCachingCredential {
+	return &cliCachingCredential{cred: cred, ttl: ttl, skew: skew, now: now, entries: map[tokenCacheKey]cliCachedToken{}}
+}
+
+func (c *cliCachingCredential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	key := newTokenCacheKey(opts)
+	// a claims challenge bypasses the cached token, which the fresh one replaces once acquired
+	if opts.Claims == "" {
+		c.mu.Lock()
+		e, ok := c.entries[key]
+		c.mu.Unlock()
+		if now := c.now(); ok && now.Before(e.until) && now.Add(c.skew).Before(e.tk.ExpiresOn) {
+			return e.tk, nil
+		}
+	}
+	tk, err := c.cred.GetToken(ctx, opts)
+	if err != nil {
+		return tk, err
+	}
+	c.mu.Lock()
+	c.entries[key] = cliCachedToken{tk: tk, until: c.now().Add(c.ttl)}
+	c.mu.Unlock()
+	return tk, nil
+}
+
+var _ azcore.TokenCredential = (*cliCachingCredential)(nil)
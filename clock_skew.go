@@ -0,0 +1,68 @@
+package azidentityext
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// defaultMaxClockSkew is the default DefaultAzureCredentialOptions.MaxClockSkew.
+const defaultMaxClockSkew = 5 * time.Minute
+
+// ErrClockSkew is wrapped by the error of GetToken when DefaultAzureCredentialOptions.VerifyClockSkew is set and the
+// clock of the host is off by more than MaxClockSkew.
+var ErrClockSkew = errors.New("the host clock is skewed, synchronize it")
+
+// issuedByAzureAD tells whether the credentials of kind get their tokens from Azure AD right away, so that the
+// Date of its response tells the time of Azure AD.
+func issuedByAzureAD(kind CredentialKind) bool {
+	switch kind {
+	case CredentialKindEnvironment, CredentialKindWorkloadIdentity, CredentialKindOnBehalfOf, CredentialKindClientAssertion:
+		return true
+	}
+	return false
+}
+
+// clockSkewPolicy records the Date of the successful token responses of Azure AD in the attempt of the request, for
+// VerifyClockSkew.
+type clockSkewPolicy struct{}
+
+func (clockSkewPolicy) Do(req *policy.Request) (*http.Response, error) {
+	resp, err := req.Next()
+	if err != nil || resp.StatusCode != http.StatusOK || !isTokenEndpoint(req.Raw()) {
+		return resp, err
+	}
+	if a := attemptFromContext(req.Raw().Context()); a != nil {
+		if date, err := http.ParseTime(resp.Header.Get("Date")); err == nil {
+			a.tokenDate.Store(date.Unix())
+		}
+	}
+	return resp, err
+}
+
+// isTokenEndpoint tells whether req is sent to the token endpoint of Azure AD or ADFS, e.g.
+// https://login.microsoftonline.com/<tenant>/oauth2/v2.0/token.
+func isTokenEndpoint(req *http.Request) bool {
+	path := strings.TrimSuffix(req.URL.Path, "/")
+	return req.Method == http.MethodPost && strings.HasSuffix(path, "/token") && strings.Contains(path, "/oauth2/")
+}
+
+// verifyClockSkew compares the clock of c with the Date of the response of Azure AD issuing a token just now.
+func (c *DefaultAzureCredential) verifyClockSkew(date time.Time) error {
+	max := c.options.MaxClockSkew
+	if max == 0 {
+		max = defaultMaxClockSkew
+	}
+	now := c.now()
+	switch {
+	case date.Sub(now) > max:
+		return fmt.Errorf("%w: Azure AD issued the token at %s, %s after the host time %s", ErrClockSkew, date.UTC().Format(time.RFC3339), date.Sub(now).Round(time.Second), now.UTC().Format(time.RFC3339))
+	case now.Sub(date) > max:
+		return fmt.Errorf("%w: Azure AD issued the token at %s, %s before the host time %s", ErrClockSkew, date.UTC().Format(time.RFC3339), now.Sub(date).Round(time.Second), now.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
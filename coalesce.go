@@ -0,0 +1,63 @@
+package azidentityext
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+)
+
+// errFlightAborted is the error of the requests waiting for a coalesced acquisition that panicked.
+var errFlightAborted = errors.New("the coalesced token request was aborted")
+
+// flightKey identifies the identical token requests coalesced by a flightGroup.
+type flightKey struct {
+	tokenCacheKey
+	claims string
+}
+
+// flight is an acquisition shared by identical concurrent requests.
+type flight struct {
+	done chan struct{}
+	tk   azcore.AccessToken
+	err  error
+}
+
+// flightGroup implements DefaultAzureCredentialOptions.CoalesceRequests.
+type flightGroup struct {
+	mu      sync.Mutex
+	flights map[flightKey]*flight
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{flights: map[flightKey]*flight{}}
+}
+
+// do calls acquire, unless an identical acquisition is in flight, in which case it waits for its result instead,
+// or for ctx to be done.
+func (g *flightGroup) do(ctx context.Context, key flightKey, acquire func() (azcore.AccessToken, error)) (azcore.AccessToken, error) {
+	g.mu.Lock()
+	if f, ok := g.flights[key]; ok {
+		g.mu.Unlock()
+		select {
+		case <-f.done:
+			return f.tk, f.err
+		case <-ctx.Done():
+			return azcore.AccessToken{}, ctx.Err()
+		}
+	}
+	// the error is that of the waiters when acquire panics, the flight being released anyway
+	f := &flight{done: make(chan struct{}), err: errFlightAborted}
+	g.flights[key] = f
+	g.mu.Unlock()
+	defer func() {
+		g.mu.Lock()
+		delete(g.flights, key)
+		g.mu.Unlock()
+		close(f.done)
+	}()
+
+	f.tk, f.err = acquire()
+	return f.tk, f.err
+}
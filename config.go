@@ -0,0 +1,139 @@
+package azidentityext
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+)
+
+// credentialConfig is the configuration file schema of NewDefaultAzureCredentialFromConfig.
+type credentialConfig struct {
+	TenantID                    string   `json:"tenantId"`
+	Cloud                       string   `json:"cloud"`
+	CredentialOrder             []string `json:"credentialOrder"`
+	DisableEnvironmentCred      bool     `json:"disableEnvironmentCredential"`
+	DisableWorkloadIdentityCred bool     `json:"disableWorkloadIdentityCredential"`
+	DisableManagedIdentityCred  bool     `json:"disableManagedIdentityCredential"`
+	DisableAzureCLICred         bool     `json:"disableAzureCliCredential"`
+	DisableInstanceDiscovery    bool     `json:"disableInstanceDiscovery"`
+	DisableEnvironmentReads     bool     `json:"disableEnvironmentReads"`
+	FailOnConstructionError     []string `json:"failOnConstructionError"`
+	ManagedIdentityClientIDs    []string `json:"managedIdentityClientIds"`
+	TokenCache                  bool     `json:"tokenCache"`
+	RefreshSkew                 string   `json:"refreshSkew"`
+	CLICommandTimeout           string   `json:"cliCommandTimeout"`
+}
+
+// configClouds are the clouds of the cloud field of the configuration file.
+var configClouds = map[string]cloud.Configuration{
+	"AzurePublic":     cloud.AzurePublic,
+	"AzureChina":      cloud.AzureChina,
+	"AzureGovernment": cloud.AzureGovernment,
+}
+
+// NewDefaultAzureCredentialFromConfig creates a DefaultAzureCredential from a JSON configuration, e.g. a deployment
+// config file, so that the chain can be changed without changing code. Its fields map to the
+// DefaultAzureCredentialOptions of the same name, all of them being optional:
+//
+//	{
+//	  "tenantId": "<tenant ID>",
+//	  "cloud": "AzurePublic",
+//	  "credentialOrder": ["WorkloadIdentityCredential", "ManagedIdentityCredential"],
+//	  "disableEnvironmentCredential": false,
+//	  "disableWorkloadIdentityCredential": false,
+//	  "disableManagedIdentityCredential": false,
+//	  "disableAzureCliCredential": true,
+//	  "disableInstanceDiscovery": false,
+//	  "disableEnvironmentReads": false,
+//	  "failOnConstructionError": ["ManagedIdentityCredential"],
+//	  "managedIdentityClientIds": ["<client ID>"],
+//	  "tokenCache": true,
+//	  "refreshSkew": "5m",
+//	  "cliCommandTimeout": "10s"
+//	}
+//
+// The cloud is one of "AzurePublic", "AzureChina" and "AzureGovernment", the durations are time.ParseDuration
+// strings, and the credential kinds are the names of SupportedCredentials, or of the kinds registered by
+// RegisterCredentialFactory. Parsing is strict: unknown fields, unknown kinds or clouds, and trailing data are
+// errors. The returned values are otherwise those of NewDefaultAzureCredential.
+func NewDefaultAzureCredentialFromConfig(r io.Reader) (*DefaultAzureCredential, []error, error) {
+	options, err := parseCredentialConfig(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	return NewDefaultAzureCredential(options)
+}
+
+func parseCredentialConfig(r io.Reader) (*DefaultAzureCredentialOptions, error) {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	var cfg credentialConfig
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("invalid credential configuration: %v", err)
+	}
+	if dec.More() {
+		return nil, errors.New("invalid credential configuration: trailing data after the configuration object")
+	}
+
+	options := &DefaultAzureCredentialOptions{
+		TenantID:                    cfg.TenantID,
+		DisableEnvironmentCred:      cfg.DisableEnvironmentCred,
+		DisableWorkloadIdentityCred: cfg.DisableWorkloadIdentityCred,
+		DisableManagedIdentityCred:  cfg.DisableManagedIdentityCred,
+		DisableAzureCLICred:         cfg.DisableAzureCLICred,
+		DisableInstanceDiscovery:    cfg.DisableInstanceDiscovery,
+		DisableEnvironmentReads:     cfg.DisableEnvironmentReads,
+		ManagedIdentityClientIDs:    cfg.ManagedIdentityClientIDs,
+		TokenCache:                  cfg.TokenCache,
+	}
+	if cfg.Cloud != "" {
+		c, ok := configClouds[cfg.Cloud]
+		if !ok {
+			return nil, fmt.Errorf("invalid credential configuration: unknown cloud %q", cfg.Cloud)
+		}
+		options.Cloud = c
+	}
+	var err error
+	if options.CredentialOrder, err = configKinds("credentialOrder", cfg.CredentialOrder); err != nil {
+		return nil, err
+	}
+	if options.FailOnConstructionError, err = configKinds("failOnConstructionError", cfg.FailOnConstructionError); err != nil {
+		return nil, err
+	}
+	if options.RefreshSkew, err = configDuration("refreshSkew", cfg.RefreshSkew); err != nil {
+		return nil, err
+	}
+	if options.CLICommandTimeout, err = configDuration("cliCommandTimeout", cfg.CLICommandTimeout); err != nil {
+		return nil, err
+	}
+	return options, nil
+}
+
+func configKinds(field string, names []string) ([]CredentialKind, error) {
+	var kinds []CredentialKind
+	for _, name := range names {
+		kind := CredentialKind(name)
+		if _, ok := credentialMeta(kind); !ok {
+			if _, ok := lookupCredentialFactory(kind); !ok {
+				return nil, fmt.Errorf("invalid credential configuration: unknown credential %q in %s", name, field)
+			}
+		}
+		kinds = append(kinds, kind)
+	}
+	return kinds, nil
+}
+
+func configDuration(field, s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid credential configuration: invalid %s %q", field, s)
+	}
+	return d, nil
+}
@@ -0,0 +1,85 @@
+package azidentityext
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+)
+
+// WithRetryPolicy returns a context that makes the network based credentials (i.e. all but the AzureCLICredential)
+// use the specified retry options in a GetToken called with it, instead of the configured ClientOptions.Retry.
+//
+// The options replace the configured ones as a whole, including the IMDS specific defaults azidentity applies for
+// the ManagedIdentityCredential, with zero valued fields getting the azcore defaults. Throttled responses are
+// still retried according to their Retry-After header, within the bounds of the specified options.
+func WithRetryPolicy(ctx context.Context, options policy.RetryOptions) context.Context {
+	return runtime.WithRetryOptions(ctx, options)
+}
+
+type tenantKey struct{}
+
+// WithTenant returns a context that makes a GetToken called with it request the token from the specified tenant.
+// It only applies when the TokenRequestOptions.TenantID of that call is empty, i.e. a tenant set by the caller
+// of GetToken takes precedence.
+func WithTenant(ctx context.Context, tenantID string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenantID)
+}
+
+func tenantFromContext(ctx context.Context) (string, bool) {
+	tenantID, ok := ctx.Value(tenantKey{}).(string)
+	return tenantID, ok
+}
+
+type correlationIDKey struct{}
+
+// WithCorrelationID returns a context that makes a GetToken called with it include the specified correlation ID
+// in the log messages it emits, so that they can be correlated with the logs of the request needing the token.
+// When a GetToken is called without a correlation ID, it generates one.
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationIDKey{}, id)
+}
+
+func correlationIDFromContext(ctx context.Context) string {
+	if id, ok := ctx.Value(correlationIDKey{}).(string); ok {
+		return id
+	}
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+type withoutCredentialsKey struct{}
+
+// WithoutCredentials returns a context that makes a GetToken called with it skip the credentials of the specified
+// kinds, e.g. to exercise the rest of the chain for a single request during a canary. The credential itself is left
+// unchanged, so concurrent calls with other contexts still attempt all of the credentials. Such a call bypasses the
+// TokenCache, which could otherwise return a token acquired by a skipped credential. The injected
+// DefaultAzureCredentialOptions.Credentials have no kind, so they can't be skipped.
+func WithoutCredentials(ctx context.Context, kinds ...CredentialKind) context.Context {
+	return context.WithValue(ctx, withoutCredentialsKey{}, kinds)
+}
+
+func withoutCredentialsFromContext(ctx context.Context) ([]CredentialKind, bool) {
+	kinds, ok := ctx.Value(withoutCredentialsKey{}).([]CredentialKind)
+	return kinds, ok && len(kinds) != 0
+}
+
+type offlineOnlyKey struct{}
+
+// OfflineOnly returns a context that makes a GetToken called with it return a cached token as long as it hasn't
+// expired, even within the RefreshSkew, and never attempt any credential, e.g. to ride out an Azure AD outage.
+// The token is looked up in the TokenCache, and then in the SharedTokenCacheFile. When no unexpired token is
+// cached, or without either option, ErrOfflineNoToken is returned.
+func OfflineOnly(ctx context.Context) context.Context {
+	return context.WithValue(ctx, offlineOnlyKey{}, true)
+}
+
+func offlineOnlyFromContext(ctx context.Context) bool {
+	offline, _ := ctx.Value(offlineOnlyKey{}).(bool)
+	return offline
+}
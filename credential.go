@@ -0,0 +1,39 @@
+package azidentityext
+
+import (
+	"context"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// Credential is implemented by *DefaultAzureCredential, so that code depending on it can be tested with a mock.
+// It covers the methods of DefaultAzureCredential, except Chain, which exposes the underlying azidentity chain.
+// Methods may be added to this interface along with DefaultAzureCredential, so mocks should embed it to keep
+// compiling, only implementing the methods they need.
+type Credential interface {
+	azcore.TokenCredential
+	GetTokens(ctx context.Context, requests []policy.TokenRequestOptions) ([]azcore.AccessToken, error)
+	Cloud() cloud.Configuration
+	ManagedIdentitySource() ManagedIdentitySource
+	LastSuccessfulCredential() string
+	LastTokenClaims() (tid string, appid string, ok bool)
+	TokenExpiry(scopes ...string) (time.Time, bool)
+	DryRun(ctx context.Context) (DryRunResult, error)
+	ExportState() CredentialState
+	ImportState(state CredentialState)
+	ReloadEnv() (credErrors []error, err error)
+	GraphViz() string
+	WaitUntilReady(ctx context.Context, interval time.Duration, scopes ...string) error
+	Reset() error
+	ForTenant(tenantID string) azcore.TokenCredential
+	InvalidateToken(scopes ...string)
+	SkippedCredentials() map[string]string
+	GetTokenForResource(ctx context.Context, resource Resource) (azcore.AccessToken, error)
+	NextRefresh(scopes ...string) (time.Time, bool)
+	EffectiveOptions() DefaultAzureCredentialOptions
+}
+
+var _ Credential = (*DefaultAzureCredential)(nil)
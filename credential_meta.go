@@ -0,0 +1,122 @@
+package azidentityext
+
+import "slices"
+
+// Capability is a property a credential kind advertises by its CredentialMeta, see
+// DefaultAzureCredentialOptions.RequireCapabilities.
+type Capability string
+
+const (
+	// NonInteractive is advertised by the credentials that never prompt a user to get a token, so that they can
+	// run unattended, e.g. in CI. Credentials relying on a prior login, like the AzureCLICredential, advertise it.
+	NonInteractive Capability = "NonInteractive"
+	// NoNetwork is advertised by the credentials that send no request over the network themselves, obtaining the
+	// tokens locally instead, e.g. from a local process, which may still reach the network.
+	NoNetwork Capability = "NoNetwork"
+	// Federation is advertised by the credentials that authenticate by exchanging a federated token issued by
+	// an external identity provider, without any secret.
+	Federation Capability = "Federation"
+)
+
+// CredentialMeta describes a built-in credential kind of DefaultAzureCredential.
+type CredentialMeta struct {
+	Kind CredentialKind
+	// Name is the human readable name of the credential.
+	Name string
+	// EnvVars are the environment variables the credential requires, when not configured by
+	// DefaultAzureCredentialOptions. Credentials with alternative configurations, e.g. EnvironmentCredential
+	// authenticating by a secret or a certificate, list only the variables common to all of them.
+	EnvVars []string
+	// Interactive tells whether the credential requires user interaction to get a token.
+	Interactive bool
+	// OptIn tells whether the credential must be enabled by DefaultAzureCredentialOptions to be part of the chain.
+	OptIn bool
+	// Experimental tells whether the credential is still being evaluated, its behavior and options possibly
+	// changing in future versions. Experimental credentials are opt-in, and are all enabled by
+	// DefaultAzureCredentialOptions.EnableExperimentalCredentials, in addition to their own option.
+	Experimental bool
+	// Capabilities are the capabilities of the credential.
+	Capabilities []Capability
+	// MaxScopes, if positive, is the number of scopes the credential can serve in a single token request, e.g. 1
+	// for the credentials requesting a token for a resource rather than for scopes.
+	MaxScopes int
+}
+
+// HasCapabilities tells whether the credential has all of the capabilities.
+func (m CredentialMeta) HasCapabilities(capabilities ...Capability) bool {
+	for _, c := range capabilities {
+		if !slices.Contains(m.Capabilities, c) {
+			return false
+		}
+	}
+	return true
+}
+
+// credentialMeta returns the metadata of a built-in credential kind.
+func credentialMeta(kind CredentialKind) (CredentialMeta, bool) {
+	for _, m := range SupportedCredentials() {
+		if m.Kind == kind {
+			return m, true
+		}
+	}
+	return CredentialMeta{}, false
+}
+
+// SupportedCredentials returns the metadata of the built-in credential kinds, in their default order.
+// Credential kinds registered by RegisterCredentialFactory aren't included.
+func SupportedCredentials() []CredentialMeta {
+	return []CredentialMeta{
+		{
+			Kind:         CredentialKindStaticToken,
+			Name:         "Static token",
+			EnvVars:      []string{defaultStaticTokenEnvVar, defaultStaticTokenExpiryEnvVar},
+			OptIn:        true,
+			Capabilities: []Capability{NonInteractive, NoNetwork},
+		},
+		{
+			Kind:         CredentialKindOnBehalfOf,
+			Name:         "On-behalf-of",
+			EnvVars:      []string{"AZURE_CLIENT_ID", "AZURE_TENANT_ID", "AZURE_CLIENT_SECRET"},
+			OptIn:        true,
+			Capabilities: []Capability{NonInteractive},
+		},
+		{
+			Kind:         CredentialKindBroker,
+			Name:         "Secrets broker",
+			OptIn:        true,
+			Capabilities: []Capability{NonInteractive},
+		},
+		{
+			Kind:         CredentialKindEnvironment,
+			Name:         "Environment",
+			EnvVars:      []string{"AZURE_TENANT_ID", "AZURE_CLIENT_ID"},
+			Capabilities: []Capability{NonInteractive},
+		},
+		{
+			Kind:         CredentialKindWorkloadIdentity,
+			Name:         "Workload identity",
+			EnvVars:      []string{"AZURE_CLIENT_ID", "AZURE_TENANT_ID", "AZURE_FEDERATED_TOKEN_FILE"},
+			Capabilities: []Capability{NonInteractive, Federation},
+		},
+		{
+			Kind:         CredentialKindClientAssertion,
+			Name:         "Client assertion",
+			EnvVars:      []string{"AZURE_CLIENT_ID", "AZURE_TENANT_ID"},
+			OptIn:        true,
+			Experimental: true,
+			Capabilities: []Capability{NonInteractive, Federation},
+		},
+		{
+			Kind:         CredentialKindManagedIdentity,
+			Name:         "Managed identity",
+			Capabilities: []Capability{NonInteractive},
+			MaxScopes:    1,
+		},
+		{
+			Kind:         CredentialKindAzureCLI,
+			Name:         "Azure CLI",
+			Capabilities: []Capability{NonInteractive, NoNetwork},
+			MaxScopes:    1,
+		},
+	}
+}
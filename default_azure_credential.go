@@ -2,11 +2,17 @@ package azidentityext
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"os"
-	"strings"
+	"log/slog"
+	"maps"
+	"net"
+	"slices"
+	"sync/atomic"
+	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
 	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 )
@@ -14,20 +20,419 @@ import (
 // DefaultAzureCredentialOptions contains optional parameters for DefaultAzureCredential.
 // These options may not apply to all credentials in the chain.
 type DefaultAzureCredentialOptions struct {
+	// ClientOptions configure the network based credentials. They are merged field by field over the defaults,
+	// so that only setting, e.g., the Cloud keeps the default transport, retry, telemetry and logging: a nil
+	// Transport is the default HTTP client, and each zero valued Retry field gets the azcore default, or for the
+	// ManagedIdentityCredential on IMDS, the IMDS specific default. Set Retry.MaxRetries to -1 to disable retries.
 	azcore.ClientOptions
 
+	// ExtraHeaders are HTTP headers added to every request of the network based credentials, e.g. a header required
+	// by a corporate gateway in front of Azure AD. The AzureCLICredential, which shells out to the CLI, ignores them.
+	// Build fails when they include a header the credentials set themselves, e.g. Authorization, Metadata or the
+	// BrokerSecretHeader.
+	ExtraHeaders map[string]string
+
+	// RespectProxyEnv makes the network based credentials use the proxy configured by the HTTPS_PROXY, HTTP_PROXY
+	// and NO_PROXY environment variables even when a custom ClientOptions.Transport is set, provided it is an
+	// *http.Client whose transport is an *http.Transport without proxy. Without a custom Transport, these
+	// environment variables are always respected. Otherwise, the custom Transport is used as is.
+	RespectProxyEnv bool
+
 	// Toggles to disabling the specified auth method
 	DisableEnvironmentCred      bool
 	DisableWorkloadIdentityCred bool
 	DisableManagedIdentityCred  bool
 	DisableAzureCLICred         bool
 
+	// PreferCertificateAuth makes the EnvironmentCredential authenticate with the certificate of
+	// AZURE_CLIENT_CERTIFICATE_PATH when AZURE_CLIENT_SECRET is also set, logging that the secret is ignored.
+	// Certificates are generally preferred over secrets, as the private key never leaves the host. By default,
+	// azidentity uses the secret in that case.
+	PreferCertificateAuth bool
+
 	// DisableInstanceDiscovery should be true for applications authenticating in disconnected or private clouds.
 	// This skips a metadata request that will fail for such applications.
 	DisableInstanceDiscovery bool
 	// TenantID identifies the tenant the Azure CLI should authenticate in.
 	// Defaults to the CLI's default tenant, which is typically the home tenant of the user logged in to the CLI.
 	TenantID string
+
+	// EnableStaticTokenCred enables the StaticTokenCredential, attempted first, which returns the access token set
+	// in the StaticTokenEnvVar environment variable, defaulting to AZURE_ACCESS_TOKEN, for any scope, e.g. for
+	// quick scripts and CI steps given a token. It bypasses Azure AD entirely, so the token must be valid for the
+	// resources it's used with. Its expiry is set in the StaticTokenExpiryEnvVar environment variable, defaulting
+	// to AZURE_ACCESS_TOKEN_EXPIRES_ON, as an RFC 3339 time or a Unix time in seconds. The credential fails to be
+	// constructed if either is missing, the expiry is malformed or the token already expired, and is unavailable
+	// once the token expires.
+	EnableStaticTokenCred   bool
+	StaticTokenEnvVar       string
+	StaticTokenExpiryEnvVar string
+
+	// EnableOnBehalfOfCred enables the on-behalf-of credential, which exchanges the user assertion of a request,
+	// set by WithUserAssertion or returned by GetUserAssertion, for a token. As that is request-scoped, the
+	// credential is only usable for the requests with a user assertion, and is skipped for the others. The
+	// requests with a user assertion are only served by this credential, never by the other credentials of the
+	// chain, which authenticate the application rather than the user.
+	// It authenticates the application by a client secret, see the OnBehalfOf* options.
+	EnableOnBehalfOfCred bool
+	// GetUserAssertion, if set, returns the user assertion of a request, when not set by WithUserAssertion. An empty
+	// assertion means the request isn't on behalf of a user, while an error fails GetToken, the request being
+	// possibly on behalf of a user whose assertion couldn't be obtained.
+	GetUserAssertion func(ctx context.Context) (string, error)
+	// OnBehalfOfClientID, OnBehalfOfTenantID and OnBehalfOfClientSecret configure the application of the
+	// on-behalf-of credential, defaulting to AZURE_CLIENT_ID, AZURE_TENANT_ID and AZURE_CLIENT_SECRET.
+	OnBehalfOfClientID     string
+	OnBehalfOfTenantID     string
+	OnBehalfOfClientSecret string
+
+	// EnableClientAssertionCred enables the ClientAssertionCredential, which authenticates the application by the
+	// assertions returned by GetClientAssertion, e.g. SPIFFE SVIDs. GetClientAssertion is called for each token
+	// acquired from Azure AD, so that rotating assertions are always fresh. ClientAssertionClientID and
+	// ClientAssertionTenantID default to AZURE_CLIENT_ID and AZURE_TENANT_ID.
+	EnableClientAssertionCred bool
+	GetClientAssertion        func(ctx context.Context) (string, error)
+	ClientAssertionClientID   string
+	ClientAssertionTenantID   string
+
+	// EnableExperimentalCredentials enables all the credentials marked Experimental in their CredentialMeta, as if
+	// their own option was set, e.g. to evaluate them together. The experimental credentials are currently:
+	//
+	//   - the ClientAssertionCredential, see EnableClientAssertionCred
+	//
+	// They are otherwise kept out of the chain, unless enabled by their own option.
+	EnableExperimentalCredentials bool
+
+	// AdditionallyAllowedTenantsByCredential, if set, specifies the tenants specific credentials may acquire tokens
+	// for, in addition to their own tenant, e.g. only for the AzureCLICredential during development. A listed
+	// credential uses its list, possibly empty, in place of the global list read from
+	// AZURE_ADDITIONALLY_ALLOWED_TENANTS, which the unlisted credentials use. As for that variable, the "*" wildcard
+	// allows any tenant. The EnvironmentCredential always reads AZURE_ADDITIONALLY_ALLOWED_TENANTS itself, so it
+	// can't be listed.
+	AdditionallyAllowedTenantsByCredential map[CredentialKind][]string
+
+	// AuthorityHostFor, if set, overrides the Azure AD authority host of specific credentials, e.g. for hybrid
+	// setups whose EnvironmentCredential and WorkloadIdentityCredential authenticate with different authorities.
+	// A listed host takes precedence over both ClientOptions.Cloud and AZURE_AUTHORITY_HOST, which the unlisted
+	// credentials use as before. It applies to the EnvironmentCredential, WorkloadIdentityCredential,
+	// OnBehalfOfCredential and ClientAssertionCredential, the others being ignored with a message in the Log. A
+	// host that isn't an absolute http(s) URL fails the construction of its credential.
+	AuthorityHostFor map[CredentialKind]string
+
+	// ValidateTenantIDs makes NewDefaultAzureCredential fail with a descriptive error when a tenant ID is invalid,
+	// e.g. mistyped, rather than the credential failing when requesting a token. The tenant IDs of the credentials
+	// of the chain, i.e. the TenantID, OnBehalfOfTenantID and ClientAssertionTenantID options, the TenantID of
+	// CredentialIdentity, and AZURE_TENANT_ID whenever a credential uses it, e.g. the EnvironmentCredential, must be
+	// GUIDs, e.g. "72f988bf-86f1-41af-91ab-2d7cd011db47", or "organizations" or "common". The additionally allowed
+	// tenants, i.e. AZURE_ADDITIONALLY_ALLOWED_TENANTS and
+	// AdditionallyAllowedTenantsByCredential, may also be the "*" wildcard. Domain names, which Azure AD accepts
+	// as tenant IDs, are rejected.
+	ValidateTenantIDs bool
+
+	// CredentialIdentity, if set, overrides the client and tenant IDs of specific credentials, which otherwise come
+	// from the environment and the global options. A non-empty ID takes precedence over both the environment
+	// variables, e.g. AZURE_CLIENT_ID, and the options specific to the credential, e.g. OnBehalfOfClientID.
+	// The IDs apply as follows, the others being ignored with a message in the Log:
+	//   - OnBehalfOfCredential, WorkloadIdentityCredential and ClientAssertionCredential: ClientID and TenantID
+	//   - ManagedIdentityCredential: ClientID, the client ID of the user-assigned identity
+	//   - AzureCLICredential: TenantID, overriding the TenantID option
+	// The EnvironmentCredential is only configured by the environment, so it can't be overridden.
+	CredentialIdentity map[CredentialKind]CredentialIDs
+
+	// BrokerEndpoint, if set, enables the BrokerCredential, which requests tokens from the local secrets broker at
+	// this URL. BrokerSecret and BrokerSecretHeader configure how it authenticates to the broker, see
+	// BrokerCredentialOptions.
+	BrokerEndpoint     string
+	BrokerSecret       string
+	BrokerSecretHeader string
+
+	// EnvLookup, if set, replaces os.LookupEnv for reading the environment variables configuring the chain:
+	// AZURE_ADDITIONALLY_ALLOWED_TENANTS, AZURE_CLIENT_ID, AZURE_TENANT_ID, AZURE_FEDERATED_TOKEN_FILE and the
+	// variables detecting the managed identity environment. The values are passed explicitly to the credentials,
+	// except for the EnvironmentCredential and the managed identity endpoint, which azidentity always reads
+	// from the process environment.
+	EnvLookup func(key string) (string, bool)
+
+	// DisableEnvironmentReads makes the chain read no environment variable, building the credentials only from these
+	// options and the Plan, whose exported fields can be set before Plan.Build. In particular:
+	//   - the EnvironmentCredential is excluded, unless listed explicitly by CredentialOrder or CredentialOrderByOS
+	//   - the WorkloadIdentityCredential requires the workload identity fields of the Plan to be set
+	//   - the ManagedIdentityCredential uses IMDS, unless ManagedIdentityTransport selects another environment
+	//   - an unset ClientOptions.Cloud defaults to the Azure public cloud, instead of reading AZURE_AUTHORITY_HOST
+	// It doesn't affect the proxy environment variables used by the default HTTP transport, nor the Azure CLI,
+	// which runs with the process environment.
+	DisableEnvironmentReads bool
+
+	// CredentialOrder, if set, specifies the kinds of credential to attempt, in order. Credentials that are disabled,
+	// either by their toggle or by lacking their required options, are skipped. Besides the built-in kinds, it can
+	// list the kinds registered by RegisterCredentialFactory.
+	// Defaults to the order documented on DefaultAzureCredential.
+	CredentialOrder []CredentialKind
+
+	// RequireCapabilities, if set, limits the chain to the built-in credentials advertising all of these
+	// capabilities by their CredentialMeta, see SupportedCredentials. The kinds registered by
+	// RegisterCredentialFactory advertise no capability, so they are excluded, whereas the injected Credentials
+	// are always kept.
+	RequireCapabilities []Capability
+
+	// IncludeCredential, if set, is called with each kind of the chain, in order, returning false to exclude it,
+	// e.g. to only include the AzureCLICredential when a given file exists. It runs last, i.e. it is only called
+	// with the kinds kept by the Disable* options, DisableEnvironmentReads, RequireCapabilities and TargetScopes, so
+	// it can exclude more credentials but not include a disabled one. The injected Credentials are always kept.
+	IncludeCredential func(kind CredentialKind) bool
+
+	// CredentialOrderByOS, if set, specifies the CredentialOrder per operating system, keyed by runtime.GOOS.
+	// It takes precedence over CredentialOrder for the listed operating systems.
+	CredentialOrderByOS map[string][]CredentialKind
+
+	// Credentials are credentials provided by the caller, attempted after the built-in ones, in order.
+	Credentials []NamedCredential
+
+	// CredentialAliases, if set, replaces the names of the built-in credentials in diagnostics, i.e. in the
+	// LastSuccessfulCredential, the metrics and the logs. The names of the credentials of the chain, including the
+	// aliases of the injected Credentials, must be unique.
+	CredentialAliases map[CredentialKind]string
+
+	// FailOnConstructionError lists the kinds of credential that are required. If any of them fails to be built,
+	// NewDefaultAzureCredential returns an error right away, instead of going on with the remaining credentials.
+	// The other credentials keep the best-effort behavior of being left out of the chain on failure.
+	FailOnConstructionError []CredentialKind
+
+	// ErrorFormatter, if set, formats the message of the error returned when no credential is successfully created,
+	// from the `credErrors`. By default, the message lists each of them.
+	ErrorFormatter func(credErrors []error) string
+
+	// ScopePolicy, if set, limits the scopes each kind of credential can serve. A credential is only attempted
+	// when each of the requested scopes matches one of its allowed scopes, otherwise it is skipped for that request.
+	// An allowed scope matches a requested scope when they are equal, or, if it ends with "*", when the requested
+	// scope starts with the part before the "*". Kinds of credential not in the map serve any scope.
+	//
+	// Setting ScopePolicy disables the memoization of the successful credential, as the chain needs to be
+	// attempted from its start for each request.
+	ScopePolicy map[CredentialKind][]string
+
+	// TargetScopes, if set, are the scopes all the tokens are requested for, e.g. only Key Vault scopes, to exclude
+	// from the chain the credentials that can't serve them in a single request, according to their CredentialMeta,
+	// see MaxScopes, and to the ScopePolicy. This is best-effort: the metadata doesn't tell whether the identity of
+	// a credential is authorized for the scopes, and the kinds registered by RegisterCredentialFactory, without
+	// metadata, as well as the injected Credentials, are always kept. Requesting other scopes isn't prevented.
+	TargetScopes []string
+
+	// ChainOptions, if set, are passed to azidentity.NewChainedTokenCredential when creating the chain. Fields this
+	// module depends on are overridden: RetrySources is always true when ScopePolicy is set.
+	ChainOptions *azidentity.ChainedTokenCredentialOptions
+
+	// ManagedIdentityTransport, if set, overrides the detected managed identity environment (see
+	// DetectManagedIdentitySource), for hosts where the detection picks the wrong endpoint. Only
+	// ManagedIdentitySourceIMDS, ManagedIdentitySourceAppService, ManagedIdentitySourceContainerApps and
+	// ManagedIdentitySourceAzureArc are supported, all but IMDS still requiring IDENTITY_ENDPOINT, as well as
+	// IDENTITY_HEADER for App Service and Container Apps.
+	ManagedIdentityTransport ManagedIdentitySource
+
+	// ManagedIdentityClientIDs, if set, are the client IDs of user-assigned identities the ManagedIdentityCredential
+	// attempts in order, e.g. on VMs with several identities, until one provides a token. The chain then has a
+	// managed identity credential per ID, named after it, e.g. "ManagedIdentityCredential(<client ID>)", followed by
+	// one for the system-assigned identity, named "ManagedIdentityCredential(system)", if
+	// ManagedIdentitySystemAssigned is set. They replace the single identity selected by AZURE_CLIENT_ID. At most 8
+	// IDs are supported, as each attempt may cost a request. When none provides a token, the GetToken error unwraps
+	// to the error of each of them.
+	ManagedIdentityClientIDs      []string
+	ManagedIdentitySystemAssigned bool
+
+	// ManagedIdentityEndpoint, if set, is the URL of the token endpoint the ManagedIdentityCredential requests, in
+	// place of the one of the detected environment, without changing the process environment. It is meant for testing
+	// against an IMDS emulator, e.g. an httptest.Server. The endpoint is requested with the IMDS protocol, unless
+	// ManagedIdentityTransport is ManagedIdentitySourceAppService or ManagedIdentitySourceContainerApps, which still
+	// read IDENTITY_HEADER.
+	ManagedIdentityEndpoint string
+
+	// ManagedIdentityDialContext, if set, dials the connections of the ManagedIdentityCredential, e.g. to reach IMDS
+	// through a specific interface, or to resolve the endpoint with a custom resolver, in isolated networks. Only
+	// the managed identity transport uses it, including the IMDS probe of DryRun, the other credentials using the
+	// ClientOptions as is. It requires ClientOptions.Transport to be unset, or an *http.Client whose transport is
+	// an *http.Transport, which is cloned with the DialContext replaced.
+	ManagedIdentityDialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// IMDSUnavailableTTL, if positive, makes the ManagedIdentityCredential remember for that long that IMDS is
+	// unreachable, skipping it without probing IMDS again. When IMDS is found unreachable, the credential is
+	// also reported as unavailable, so that the chain goes on with its next credential. It has no effect outside
+	// of the IMDS environment.
+	IMDSUnavailableTTL time.Duration
+
+	// CoalesceRequests makes the concurrent GetToken calls for the same scopes, tenant, claims and CAE setting share
+	// a single acquisition, whose token or error is returned to all of them. The acquisition runs with the context of
+	// the call that started it, the other calls only waiting for it until their own context is done. Calls with
+	// WithoutCredentials are never coalesced.
+	CoalesceRequests bool
+
+	// MaxChainAttempts, if positive, bounds the attempts of a GetToken call, to cap its latency when the credentials
+	// fail. Each call of a credential counts as one attempt, and so does each HTTP request a credential sends
+	// beyond its first one, i.e. its retries, including those of throttled requests, and its additional requests,
+	// e.g. the instance discovery. Once the bound is reached, the request exceeding it fails, and the chain stops
+	// without attempting its next credentials: GetToken fails with an error wrapping ErrMaxChainAttempts, which
+	// unwraps to the errors of the attempted credentials.
+	MaxChainAttempts int
+
+	// CLICommandTimeout bounds each invocation of the Azure CLI by the AzureCLICredential, even when the context has
+	// a later deadline. A timeout reports the credential as unavailable, so that the chain goes on with its next
+	// credential. Defaults to 10 seconds.
+	CLICommandTimeout time.Duration
+
+	// TimeoutMultiplier, if set, scales the default timeouts of this module, e.g. by 3 on slow CI agents where they
+	// cause spurious fallbacks to the next credential: the default CLICommandTimeout, the wait for the federated
+	// token file to be written, the wait for the lock of the SharedTokenCacheFile and the probes of DryRun. The
+	// timeouts set explicitly, e.g. CLICommandTimeout, are used as is, as are the deadlines of the contexts and
+	// the timeouts of azidentity itself. Build fails for a negative or non finite value. Defaults to 1.
+	TimeoutMultiplier float64
+
+	// CLIRunner, if set, runs the Azure CLI for the AzureCLICredential, in place of invoking the az executable, e.g.
+	// to test the handling of the login states and timeouts of the CLI with canned outputs. CLICommandTimeout and
+	// CLITokenCacheTTL still apply. Defaults to azidentity invoking the CLI.
+	CLIRunner CLIRunner
+
+	// NormalizeScopes, if set, canonicalizes the scopes of each GetToken call, e.g. turning resource URLs into
+	// "/.default" scopes, before they are passed to the credentials. As it runs before the token cache is looked
+	// up, the calls whose scopes normalize to the same scopes share their cached token. It's passed a copy of the
+	// scopes, which it may modify. Defaults to leaving the scopes as is.
+	NormalizeScopes func(scopes []string) []string
+
+	// CLITokenCacheTTL, if positive, makes the AzureCLICredential reuse a token for the same scopes and tenant
+	// for up to that long, instead of invoking the CLI for each request. A token is never reused within the
+	// RefreshSkew of its expiry. Unlike TokenCache, it only applies to the CLI.
+	CLITokenCacheTTL time.Duration
+
+	// TokenCache enables an in-memory cache of the tokens returned by GetToken, so that the credentials are only
+	// attempted when no fresh token is cached. Tokens are keyed by:
+	//   - the requested scopes, in any order, after NormalizeScopes
+	//   - the requested tenant, including the one set by WithTenant
+	//   - the EnableCAE setting of the request
+	//   - the hash of the user assertion, for the on-behalf-of credential
+	//   - the DisableInstanceDiscovery option
+	// Claims aren't part of the key, a request with claims replacing the cached token instead.
+	TokenCache bool
+
+	// RefreshSkew is how long before its expiry a cached token is refreshed. Defaults to 5 minutes.
+	RefreshSkew time.Duration
+
+	// MaxTokenLifetime, if positive, caps the ExpiresOn returned by GetToken to the acquisition time plus
+	// MaxTokenLifetime. The token itself is unchanged, it's only treated as expired sooner, by TokenCache and by the
+	// Azure SDK clients, which refresh it earlier. This is defense in depth: a leaked token held by this process is
+	// then replaced sooner, and a revoked grant or a removed role assignment is noticed sooner, at the cost of more
+	// token requests. It should be larger than RefreshSkew, otherwise cached tokens are never reused.
+	MaxTokenLifetime time.Duration
+
+	// SharedTokenCacheFile, if set, is a file caching the tokens returned by GetToken for all the processes of the
+	// host using it, e.g. short-lived CLI tools running concurrently, so that they reuse each other's tokens rather
+	// than each acquiring its own. It works like TokenCache, including RefreshSkew, and can be combined with it, the
+	// file then only being read on a miss of the in-memory cache. The writers are serialized by an advisory lock
+	// file next to it, a lock older than 10 seconds being broken as left behind by a crashed process. When the lock
+	// can't be acquired in time or the file can't be read, the shared cache is skipped for that call. The tokens
+	// are keyed as in TokenCache, and by the client and tenant IDs and the broker endpoint configuring the chain.
+	// Only the tokens of the credentials whose identity these determine are written, i.e. not those acquired on
+	// behalf of a user, nor those of the AzureCLICredential, whose identity is the account logged in the CLI, of the
+	// static token credential, of the injected Credentials and of the kinds registered by
+	// RegisterCredentialFactory.
+	//
+	// The file holds bearer tokens in clear: anyone able to read it can use them until they expire. It's created
+	// readable by its owner only, but its directory should be private to the user running the processes, and it
+	// must not be used on hosts shared with untrusted users, nor on network file systems. It's unrelated to, and
+	// not compatible with, the persistent cache of azidentity.
+	SharedTokenCacheFile string
+
+	// AdaptiveOrdering makes GetToken attempt the credential that provided the last token first, before the chain,
+	// which is then only attempted in its order if that credential fails, e.g. when the chain retries its sources
+	// (see ScopePolicy and ChainOptions.RetrySources), so that a credential low in the chain that keeps succeeding
+	// doesn't cost a probe of the credentials before it on each request. Once the preferred credential fails, the
+	// chain order applies again, until a credential succeeds.
+	AdaptiveOrdering bool
+
+	// CredentialHintFile, if set, is a file persisting the name of the last successful credential, for short-lived
+	// processes like CLI tools. When a process starts, the credential named by the file is attempted first, the
+	// chain being only attempted once it fails. A missing or corrupt file is ignored. The file only holds the name
+	// of a credential, no token.
+	CredentialHintFile string
+
+	// NoNetwork replaces each credential of the chain with a stub doing no I/O, for testing and benchmarking the
+	// chain itself. The stubs are unavailable, except the last one, which returns a fake token.
+	// It must not be set in production.
+	NoNetwork bool
+
+	// Metrics, if set, receives the metrics of the token acquisitions.
+	Metrics MetricsSink
+
+	// Log, if set, receives the diagnostic messages of this module, e.g. the detected managed identity environment,
+	// or the token acquisition attempts of the credentials, which include the correlation ID of their GetToken call
+	// (see WithCorrelationID).
+	Log func(msg string)
+
+	// Logger, if set, receives the token acquisition events of the credentials of the chain as slog records:
+	// "credential attempt" (debug), "credential success" (info), "credential failure" (warn) and "credential skipped"
+	// (debug), as well as "credential fallback" (info) when the chain advances to the next credential. Their
+	// attributes have the stable keys "credential", "correlation_id", and depending on the event, "duration",
+	// "error", "reason" and "from".
+	Logger *slog.Logger
+
+	// AfterGetToken, if set, is called with each token acquired by a credential of the chain, along with the name of
+	// that credential, before GetToken returns it. The token it returns is returned instead, which is meant for
+	// rare cases: prefer inspecting the token to modifying it. An error fails the GetToken call. It isn't called for
+	// the tokens returned from the TokenCache, which caches the tokens it returns.
+	AfterGetToken func(ctx context.Context, name string, tk azcore.AccessToken) (azcore.AccessToken, error)
+
+	// OnFallback, if set, is called each time a GetToken call advances from the credential named from to the next
+	// one, named to. It isn't called for the first credential attempted.
+	OnFallback func(from, to string)
+
+	// VerifyClockSkew, if set, compares the clock of the host with the Date header of the response of Azure AD
+	// when it issues a token to the Environment, WorkloadIdentity, OnBehalfOf or ClientAssertion credential, as a
+	// host clock off by minutes makes valid tokens look expired or not yet valid, causing confusing authentication
+	// failures. When the skew exceeds MaxClockSkew, a warning is logged and GetToken fails with an error wrapping
+	// ErrClockSkew, until a token passes the check. The tokens of the other credentials, e.g. AzureCLI, StaticToken
+	// or ManagedIdentity, and the cached tokens, aren't checked, their issue time being unrelated to the host clock.
+	VerifyClockSkew bool
+	// MaxClockSkew is the largest clock skew accepted by VerifyClockSkew. Defaults to 5 minutes.
+	MaxClockSkew time.Duration
+
+	// OnInteractiveFallback, if set, is called with the name of an interactive credential each time GetToken is
+	// about to attempt it, when a warning is also logged to Log. The built-in credentials are never interactive,
+	// only the injected Credentials marked Interactive are. Falling back to such a credential in an unattended
+	// process is almost always a misconfiguration, which would otherwise show as GetToken hanging, e.g. waiting for
+	// a browser.
+	OnInteractiveFallback func(name string)
+
+	// OnSelected, if set, is called with the name of the credential providing the first token acquired by the
+	// chain, at the same time as the "selected credential" message is logged to Log. Neither happens again for
+	// the later tokens, until Reset is called.
+	OnSelected func(name string)
+}
+
+// CredentialIDs are the client and tenant IDs of a credential, see DefaultAzureCredentialOptions.CredentialIdentity.
+type CredentialIDs struct {
+	ClientID string
+	TenantID string
+}
+
+// NamedCredential is a credential provided by the caller to be part of the chain.
+type NamedCredential struct {
+	// Alias is the name of the credential in diagnostics. It is required.
+	Alias      string
+	Credential azcore.TokenCredential
+	// Interactive tells whether the credential prompts a user to get a token, e.g. an
+	// azidentity.InteractiveBrowserCredential, so that attempting it is warned about, see
+	// DefaultAzureCredentialOptions.OnInteractiveFallback.
+	Interactive bool
+}
+
+// scaleTimeout scales the default timeout d by the TimeoutMultiplier.
+func (o *DefaultAzureCredentialOptions) scaleTimeout(d time.Duration) time.Duration {
+	if o.TimeoutMultiplier <= 0 {
+		return d
+	}
+	return time.Duration(float64(d) * o.TimeoutMultiplier)
+}
+
+func (o *DefaultAzureCredentialOptions) logf(format string, a ...any) {
+	if o.Log != nil {
+		o.Log(redact(fmt.Sprintf(format, a...)))
+	}
 }
 
 // DefaultAzureCredential is a default credential chain for applications that will deploy to Azure.
@@ -35,88 +440,326 @@ type DefaultAzureCredentialOptions struct {
 // It attempts to authenticate with each of these credential types, in the following order, stopping
 // when one provides a token:
 //
+//   - the static token credential, if DefaultAzureCredentialOptions.EnableStaticTokenCred is set
+//   - the on-behalf-of credential, if DefaultAzureCredentialOptions.EnableOnBehalfOfCred is set, for the requests
+//     with a user assertion (see [WithUserAssertion])
+//   - [BrokerCredential], if DefaultAzureCredentialOptions.BrokerEndpoint is set
 //   - [EnvironmentCredential]
 //   - [WorkloadIdentityCredential], if environment variable configuration is set by the Azure workload
 //     identity webhook. Use [WorkloadIdentityCredential] directly when not using the webhook or needing
 //     more control over its configuration.
-//   - [ManagedIdentityCredential]
+//   - [ClientAssertionCredential], if DefaultAzureCredentialOptions.EnableClientAssertionCred or
+//     EnableExperimentalCredentials is set
+//   - [ManagedIdentityCredential], which supports the IMDS, App Service, Container Apps, Service Fabric, Azure Arc
+//     and Cloud Shell environments. See [DetectManagedIdentitySource] for how the environment is detected.
 //   - [AzureCLICredential]
+//   - the credentials set in DefaultAzureCredentialOptions.Credentials
 //
 // Consult the documentation for these credential types for more information on how they authenticate.
 // Once a credential has successfully authenticated, DefaultAzureCredential will use that credential for
 // every subsequent authentication.
 type DefaultAzureCredential struct {
-	chain *azidentity.ChainedTokenCredential
+	state   atomic.Pointer[chainState]
+	options DefaultAzureCredentialOptions
+	metrics MetricsSink
+	now     func() time.Time
+
+	cache       *tokenCache
+	shared      *sharedTokenCache
+	refreshSkew time.Duration
+	flights     *flightGroup
+
+	lastSuccessful atomic.Value
+	// lastClaims are the tokenClaims of the last acquired token
+	lastClaims atomic.Value
+	// clockVerified tells whether a token passed the VerifyClockSkew check
+	clockVerified atomic.Bool
+	// selected tells whether a credential was selected since the credential was created or Reset, see OnSelected
+	selected atomic.Bool
+}
+
+// chainState is the chain of a DefaultAzureCredential, built from a Plan, and replaced as a whole by ReloadEnv.
+type chainState struct {
+	chain       *azidentity.ChainedTokenCredential
+	credentials []*wrappedCredential
+	plan        *Plan
+	// fingerprint is the clientFingerprint of the plan, keying the tokens of the SharedTokenCacheFile
+	fingerprint string
+	hint        *credentialHint
+	// obo tells whether the on-behalf-of credential is part of the chain
+	obo bool
+	// skipped are the reasons of the credentials that aren't part of the chain, see SkippedCredentials
+	skipped map[string]string
 }
 
 // NewDefaultAzureCredential creates a DefaultAzureCredential. Pass nil for options to accept defaults.
-// Some credentials builder function might return error, which will be returned in the `credErrors`,
-// in which case that failed credential will not be included as part of the returned `cred`.
-// If all the possible creds are all failed to build, non nil `err` will be returned.
+// It is a shorthand of NewPlan(options).Build(), see [Plan.Build] for the returned values and their ordering.
 func NewDefaultAzureCredential(options *DefaultAzureCredentialOptions) (cred *DefaultAzureCredential, credErrors []error, err error) {
-	var creds []azcore.TokenCredential
+	return NewPlan(options).Build()
+}
 
-	if options == nil {
-		options = &DefaultAzureCredentialOptions{}
-	}
+// Chain returns the underlying ChainedTokenCredential, as an escape hatch for APIs requiring that concrete type.
+// Its sources are the credentials of the chain, but getting tokens from it directly bypasses the processing done
+// by DefaultAzureCredential.GetToken, e.g. the returned error doesn't unwrap to the per-credential errors.
+func (c *DefaultAzureCredential) Chain() *azidentity.ChainedTokenCredential {
+	return c.state.Load().chain
+}
 
-	var additionalTenants []string
-	if v, ok := os.LookupEnv("AZURE_ADDITIONALLY_ALLOWED_TENANTS"); ok {
-		additionalTenants = strings.Split(v, ";")
+// Cloud returns the cloud the credentials authenticate in, i.e. the cloud configured in the ClientOptions, or else
+// the cloud of the AZURE_AUTHORITY_HOST environment variable, defaulting to the public cloud, as reported by
+// EffectiveOptions.
+func (c *DefaultAzureCredential) Cloud() cloud.Configuration {
+	return c.state.Load().plan.effectiveCloud()
+}
+
+// ManagedIdentitySource returns the managed identity environment detected when the chain was built, i.e. when the
+// credential was created or by the last ReloadEnv rebuilding it.
+func (c *DefaultAzureCredential) ManagedIdentitySource() ManagedIdentitySource {
+	return c.state.Load().plan.ManagedIdentitySource
+}
+
+// GetToken requests an access token from Azure Active Directory. This method is called automatically by Azure SDK clients.
+// When ctx is already done, its error is returned right away, without attempting any credential. ctx is passed to
+// every credential, and GetToken returns once it's done, even if a credential doesn't honor it.
+// When the TokenCache option is set, a cached token is returned if it doesn't expire within the RefreshSkew.
+// A request with Claims, i.e. answering a claims challenge, always acquires a fresh token, passing the claims to the
+// credentials unchanged: the fresh token replaces the cached one for the same scopes and tenant when acquired,
+// which is kept when the acquisition fails.
+// When no credential provides a token, the returned error supports Unwrap() []error, which returns the error each
+// attempted credential returned during this call.
+func (c *DefaultAzureCredential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	// a done context would only make the credentials fail with misleading errors
+	if err := ctx.Err(); err != nil {
+		return azcore.AccessToken{}, err
+	}
+	if normalize := c.options.NormalizeScopes; normalize != nil {
+		opts.Scopes = normalize(slices.Clone(opts.Scopes))
+	}
+	if tenantID, ok := tenantFromContext(ctx); ok && opts.TenantID == "" {
+		opts.TenantID = tenantID
+	}
+	if c.state.Load().obo {
+		ctx = c.resolveUserAssertion(ctx)
+		// the request may be on behalf of a user, so it must not get a token of the application instead
+		if ua, _ := userAssertionFromContext(ctx); ua.err != nil {
+			if excluded, _ := withoutCredentialsFromContext(ctx); !slices.Contains(excluded, CredentialKindOnBehalfOf) {
+				return azcore.AccessToken{}, fmt.Errorf("%s: failed to get the user assertion: %w", CredentialKindOnBehalfOf, ua.err)
+			}
+		}
+	}
+	key := c.tokenCacheKey(opts)
+	key.userAssertion = hashUserAssertion(ctx)
+	if offlineOnlyFromContext(ctx) {
+		if tk, ok := c.cachedToken(key, 0); ok {
+			c.metrics.CacheHit(key.String())
+			return tk, nil
+		}
+		return azcore.AccessToken{}, ErrOfflineNoToken
+	}
+	if _, ok := withoutCredentialsFromContext(ctx); ok {
+		return c.getToken(ctx, opts)
+	}
+	if c.cache == nil && c.shared == nil {
+		return c.coalescedGetToken(ctx, key, opts)
+	}
+	// a claims challenge means the resource rejected the cached token, which the fresh one replaces once acquired
+	if opts.Claims == "" {
+		if tk, ok := c.cachedToken(key, c.refreshSkew); ok {
+			c.metrics.CacheHit(key.String())
+			return tk, nil
+		}
+	}
+	tk, err := c.coalescedGetToken(ctx, key, opts)
+	if err != nil {
+		return tk, err
 	}
+	if c.cache != nil {
+		c.cache.set(key, tk)
+	}
+	return tk, nil
+}
 
-	envCred, err := azidentity.NewEnvironmentCredential(&azidentity.EnvironmentCredentialOptions{
-		ClientOptions: options.ClientOptions, DisableInstanceDiscovery: options.DisableInstanceDiscovery},
-	)
-	if err == nil {
-		creds = append(creds, envCred)
-	} else {
-		credErrors = append(credErrors, fmt.Errorf("EnvironmentCredential: %v", err))
+// cachedToken returns the token cached for key that doesn't expire within skew, from the TokenCache, or else from
+// the SharedTokenCacheFile, caching it in the TokenCache.
+func (c *DefaultAzureCredential) cachedToken(key tokenCacheKey, skew time.Duration) (azcore.AccessToken, bool) {
+	fresh := func(tk azcore.AccessToken) bool { return c.now().Add(skew).Before(tk.ExpiresOn) }
+	if c.cache != nil {
+		if tk, ok := c.cache.get(key); ok && fresh(tk) {
+			return tk, true
+		}
+	}
+	// the tokens acquired on behalf of a user are never shared
+	if c.shared == nil || key.userAssertion != "" {
+		return azcore.AccessToken{}, false
+	}
+	tk, ok := c.shared.get(sharedKey(key, c.state.Load().fingerprint))
+	if !ok || !fresh(tk) {
+		return azcore.AccessToken{}, false
 	}
+	if c.cache != nil {
+		c.cache.set(key, tk)
+	}
+	return tk, true
+}
 
-	// workload identity requires values for AZURE_AUTHORITY_HOST, AZURE_CLIENT_ID, AZURE_FEDERATED_TOKEN_FILE, AZURE_TENANT_ID
-	wic, err := azidentity.NewWorkloadIdentityCredential(&azidentity.WorkloadIdentityCredentialOptions{
-		AdditionallyAllowedTenants: additionalTenants,
-		ClientOptions:              options.ClientOptions,
-		DisableInstanceDiscovery:   options.DisableInstanceDiscovery,
+// coalescedGetToken calls getToken, sharing its result with the identical concurrent requests when the
+// CoalesceRequests option is set.
+func (c *DefaultAzureCredential) coalescedGetToken(ctx context.Context, key tokenCacheKey, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	if c.flights == nil {
+		return c.getToken(ctx, opts)
+	}
+	return c.flights.do(ctx, flightKey{tokenCacheKey: key, claims: opts.Claims}, func() (azcore.AccessToken, error) {
+		return c.getToken(ctx, opts)
 	})
-	if err == nil {
-		creds = append(creds, wic)
-	} else {
-		credErrors = append(credErrors, fmt.Errorf("NetworkloadIdentityCredential: %v", err))
+}
+
+// getToken requests an access token from the credentials, bypassing the token cache.
+func (c *DefaultAzureCredential) getToken(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	a := &attempt{correlationID: correlationIDFromContext(ctx), maxAttempts: int32(c.options.MaxChainAttempts)}
+	ctx = withAttempt(ctx, a)
+	st := c.state.Load()
+	chain := st.chain
+	excluded, _ := withoutCredentialsFromContext(ctx)
+	// acquired also writes the token to the SharedTokenCacheFile, unless requested without some credentials, which
+	// bypasses the caches
+	acquired := func(name string, tk azcore.AccessToken) (azcore.AccessToken, error) {
+		tk, err := c.acquired(ctx, name, tk)
+		if err == nil && len(excluded) == 0 {
+			c.share(ctx, st, opts, name, tk)
+		}
+		return tk, err
 	}
-	o := &azidentity.ManagedIdentityCredentialOptions{ClientOptions: options.ClientOptions}
-	if ID, ok := os.LookupEnv("AZURE_CLIENT_ID"); ok {
-		o.ID = azidentity.ClientID(ID)
+	if len(excluded) != 0 {
+		var err error
+		if chain, err = st.chainWithout(excluded); err != nil {
+			return azcore.AccessToken{}, err
+		}
 	}
-	miCred, err := azidentity.NewManagedIdentityCredential(o)
-	if err == nil {
-		creds = append(creds, miCred)
-	} else {
-		credErrors = append(credErrors, fmt.Errorf("ManagedIdentityCredential: %v", err))
+	// a request on behalf of a user must get its token from the on-behalf-of credential, never from a credential
+	// of the application, which the chain would memoize, or prefer by the hint
+	if ua, _ := userAssertionFromContext(ctx); ua.value != "" && st.obo && !slices.Contains(excluded, CredentialKindOnBehalfOf) {
+		for _, cred := range st.credentials {
+			if cred.kind == CredentialKindOnBehalfOf {
+				tk, err := cred.GetToken(ctx, opts)
+				if err != nil {
+					return tk, err
+				}
+				return c.acquired(ctx, cred.name, tk)
+			}
+		}
+		return azcore.AccessToken{}, errors.New("the request has a user assertion, but the on-behalf-of credential failed to be built")
 	}
-
-	cliCred, err := azidentity.NewAzureCLICredential(&azidentity.AzureCLICredentialOptions{AdditionallyAllowedTenants: additionalTenants, TenantID: options.TenantID})
-	if err == nil {
-		creds = append(creds, cliCred)
-	} else {
-		credErrors = append(credErrors, fmt.Errorf("AzureCLICredential: %v", err))
+	if hint := st.hint; hint != nil {
+		if cred := hint.preferredCredential(); cred != nil && !slices.Contains(excluded, cred.kind) {
+			tk, err := cred.GetToken(ctx, opts)
+			if err == nil {
+				return acquired(cred.name, tk)
+			}
+			hint.drop(cred)
+		}
 	}
+	tk, err := chain.GetToken(ctx, opts)
+	if err != nil {
+		if len(a.errs) != 0 {
+			return tk, &chainError{err: err, errs: a.errs}
+		}
+		return tk, err
+	}
+	if st.hint != nil {
+		st.hint.record(a.succeeded)
+		if c.options.AdaptiveOrdering {
+			if cred := st.hint.credential(a.succeeded); cred != nil {
+				st.hint.prefer(cred)
+			}
+		}
+	}
+	return acquired(a.succeeded, tk)
+}
 
+// chainWithout returns a new chain of the credentials not of the excluded kinds, see WithoutCredentials.
+func (st *chainState) chainWithout(excluded []CredentialKind) (*azidentity.ChainedTokenCredential, error) {
+	var creds []azcore.TokenCredential
+	for _, cred := range st.credentials {
+		if cred.kind == "" || !slices.Contains(excluded, cred.kind) {
+			creds = append(creds, cred)
+		}
+	}
 	if len(creds) == 0 {
-		return nil, credErrors, fmt.Errorf("no credential successfully created")
+		return nil, errors.New("all the credentials of the chain are excluded by WithoutCredentials")
 	}
+	return azidentity.NewChainedTokenCredential(creds, nil)
+}
 
-	chain, err := azidentity.NewChainedTokenCredential(creds, nil)
-	if err != nil {
-		return nil, credErrors, err
+// acquired records the token acquired by the credential named name, capping its expiry to MaxTokenLifetime, before
+// passing it to the AfterGetToken hook.
+func (c *DefaultAzureCredential) acquired(ctx context.Context, name string, tk azcore.AccessToken) (azcore.AccessToken, error) {
+	if max := c.options.MaxTokenLifetime; max > 0 {
+		if capped := c.now().Add(max); capped.Before(tk.ExpiresOn) {
+			tk.ExpiresOn = capped
+		}
+	}
+	c.lastSuccessful.Store(name)
+	claims := parseTokenClaims(tk.Token)
+	c.lastClaims.Store(claims)
+	if a := attemptFromContext(ctx); c.options.VerifyClockSkew && !c.clockVerified.Load() && a != nil && a.tokenDate.Load() != 0 && issuedByAzureAD(a.succeededKind) {
+		if err := c.verifyClockSkew(time.Unix(a.tokenDate.Load(), 0)); err != nil {
+			c.options.logf("warning: %v", err)
+			return azcore.AccessToken{}, err
+		}
+		c.clockVerified.Store(true)
+	}
+	if c.selected.CompareAndSwap(false, true) {
+		c.options.logf("selected credential %s", name)
+		if c.options.OnSelected != nil {
+			c.options.OnSelected(name)
+		}
+	}
+	if c.options.AfterGetToken == nil {
+		return tk, nil
 	}
-	return &DefaultAzureCredential{chain: chain}, credErrors, nil
+	return c.options.AfterGetToken(ctx, name, tk)
 }
 
-// GetToken requests an access token from Azure Active Directory. This method is called automatically by Azure SDK clients.
-func (c *DefaultAzureCredential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
-	return c.chain.GetToken(ctx, opts)
+// Reset forgets the credential selected by the chain, so that the next GetToken attempts the credentials from the
+// first one again, and the selection is logged and reported to OnSelected again. It also drops the credential
+// preferred by CredentialHintFile or ImportState, and LastSuccessfulCredential returns an empty string until a token
+// is acquired. The TokenCache is kept, its tokens still being returned until they need a refresh.
+func (c *DefaultAzureCredential) Reset() error {
+	for {
+		old := c.state.Load()
+		creds := make([]azcore.TokenCredential, len(old.credentials))
+		for i, w := range old.credentials {
+			creds[i] = w
+		}
+		chain, err := azidentity.NewChainedTokenCredential(creds, old.plan.chainOptions())
+		if err != nil {
+			return err
+		}
+		st := *old
+		st.chain = chain
+		if c.state.CompareAndSwap(old, &st) {
+			break
+		}
+	}
+	c.state.Load().hint.prefer(nil)
+	c.lastSuccessful.Store("")
+	c.selected.Store(false)
+	return nil
+}
+
+// SkippedCredentials returns the credentials of the credential order that aren't part of the chain, keyed by name,
+// i.e. by alias for those having one, along with the reason they were skipped: disabled by an option, opt-in and
+// not enabled, excluded by RequireCapabilities or IncludeCredential, or failed to build, e.g. as the environment
+// doesn't configure them. The latter also include the construction error. The returned map is a copy.
+func (c *DefaultAzureCredential) SkippedCredentials() map[string]string {
+	return maps.Clone(c.state.Load().skipped)
+}
+
+// LastSuccessfulCredential returns the name of the credential that provided the last token, i.e. its alias if
+// it has one. It returns an empty string until a token is acquired.
+func (c *DefaultAzureCredential) LastSuccessfulCredential() string {
+	name, _ := c.lastSuccessful.Load().(string)
+	return name
 }
 
 var _ azcore.TokenCredential = (*DefaultAzureCredential)(nil)
@@ -0,0 +1,115 @@
+package azidentityext
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// dryRunDialTimeout bounds the reachability probes of DryRun.
+const dryRunDialTimeout = time.Second
+
+// DryRunResult reports the outcome of DefaultAzureCredential.DryRun.
+type DryRunResult struct {
+	// Selected is the name of the first credential of the chain that is likely to provide a token, or empty if none is.
+	Selected string
+	// Credentials are the checks of each credential of the chain, in order.
+	Credentials []DryRunCheck
+}
+
+// DryRunCheck reports the local viability check of a credential of the chain.
+type DryRunCheck struct {
+	Name   string
+	Viable bool
+	Reason string
+}
+
+// DryRun reports which credential of the chain is likely to provide a token first, without acquiring any token.
+// It only runs local checks for each credential: whether its configuration is present, whether the Azure CLI is
+// installed, unless run by the CLIRunner, whether the federated token file exists, and whether the managed
+// identity or broker endpoint is reachable, the latter being a cheap TCP connection attempt. Passing the checks
+// doesn't guarantee that the credential can authenticate. The error is only non nil when ctx is done.
+func (c *DefaultAzureCredential) DryRun(ctx context.Context) (DryRunResult, error) {
+	var res DryRunResult
+	for _, w := range c.state.Load().credentials {
+		viable, reason := c.dryRunCheck(ctx, w)
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+		res.Credentials = append(res.Credentials, DryRunCheck{Name: w.name, Viable: viable, Reason: reason})
+		if viable && res.Selected == "" {
+			res.Selected = w.name
+		}
+	}
+	return res, nil
+}
+
+func (c *DefaultAzureCredential) dryRunCheck(ctx context.Context, w *wrappedCredential) (bool, string) {
+	if stub, ok := w.cred.(*stubCredential); ok {
+		return stub.available, "stubbed by NoNetwork"
+	}
+	p := c.state.Load().plan
+	switch w.kind {
+	case CredentialKindBroker:
+		u, err := url.Parse(p.options.BrokerEndpoint)
+		if err != nil {
+			return false, fmt.Sprintf("invalid broker endpoint: %v", err)
+		}
+		return dryRunDial(ctx, u, "broker endpoint", c.options.scaleTimeout(dryRunDialTimeout), nil)
+	case CredentialKindEnvironment:
+		return true, "the environment variables of a service principal or user are set"
+	case CredentialKindWorkloadIdentity:
+		file := p.WorkloadIdentityTokenFilePath
+		if file == "" && !p.options.DisableEnvironmentReads {
+			file = os.Getenv("AZURE_FEDERATED_TOKEN_FILE")
+		}
+		if _, err := os.Stat(file); err != nil {
+			return false, fmt.Sprintf("federated token file: %v", err)
+		}
+		return true, fmt.Sprintf("federated token file %s exists", file)
+	case CredentialKindManagedIdentity:
+		endpoint := p.managedIdentityEndpoint()
+		u, err := url.Parse(endpoint)
+		if err != nil || u.Host == "" {
+			return false, fmt.Sprintf("invalid %s managed identity endpoint %q", p.ManagedIdentitySource, endpoint)
+		}
+		return dryRunDial(ctx, u, fmt.Sprintf("%s managed identity endpoint", p.ManagedIdentitySource), c.options.scaleTimeout(dryRunDialTimeout), c.options.ManagedIdentityDialContext)
+	case CredentialKindAzureCLI:
+		if c.options.CLIRunner != nil {
+			return true, "the Azure CLI is run by the CLIRunner, the login state isn't checked"
+		}
+		path, err := exec.LookPath("az")
+		if err != nil {
+			return false, "Azure CLI not found on path"
+		}
+		return true, fmt.Sprintf("Azure CLI found at %s, the login state isn't checked", path)
+	}
+	return true, "no local check available for this credential"
+}
+
+// dryRunDial probes the host of u, with dial if not nil.
+func dryRunDial(ctx context.Context, u *url.URL, target string, timeout time.Duration, dial func(ctx context.Context, network, addr string) (net.Conn, error)) (bool, string) {
+	host := u.Host
+	if u.Port() == "" {
+		port := "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+		host = net.JoinHostPort(u.Hostname(), port)
+	}
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	conn, err := dial(ctx, "tcp", host)
+	if err != nil {
+		return false, fmt.Sprintf("%s is unreachable: %v", target, err)
+	}
+	conn.Close()
+	return true, fmt.Sprintf("%s is reachable", target)
+}
@@ -0,0 +1,105 @@
+package azidentityext
+
+import (
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+)
+
+// EffectiveOptions returns a copy of the options the credential was created with, completed with the defaults
+// derived from the environment when the chain was built, i.e. when the credential was created or by the last
+// ReloadEnv rebuilding it, to debug what NewDefaultAzureCredential decided:
+//
+//   - Cloud is the cloud of AZURE_AUTHORITY_HOST, defaulting to the Azure public cloud, when the ClientOptions
+//     set none. Only the authority host is set for an authority host of no known cloud.
+//   - CredentialIdentity holds the client and tenant IDs each credential of the chain uses, e.g. read from
+//     AZURE_CLIENT_ID and AZURE_TENANT_ID.
+//   - AdditionallyAllowedTenantsByCredential holds the tenants each credential of the chain with a tenant ID may
+//     acquire tokens for, e.g. parsed from AZURE_ADDITIONALLY_ALLOWED_TENANTS, but for the EnvironmentCredential,
+//     which can't be listed.
+//   - OnBehalfOfClientID, OnBehalfOfTenantID, ClientAssertionClientID and ClientAssertionTenantID are the IDs of
+//     the on-behalf-of and client assertion credentials.
+//
+// The secrets, i.e. OnBehalfOfClientSecret, BrokerSecret and the values of ExtraHeaders, are redacted. See Plan
+// and SkippedCredentials for the credentials of the chain.
+func (c *DefaultAzureCredential) EffectiveOptions() DefaultAzureCredentialOptions {
+	p := c.state.Load().plan
+	o := p.options
+
+	o.Cloud = p.effectiveCloud()
+
+	ids := maps.Clone(o.CredentialIdentity)
+	if ids == nil {
+		ids = map[CredentialKind]CredentialIDs{}
+	}
+	tenants := maps.Clone(o.AdditionallyAllowedTenantsByCredential)
+	if tenants == nil {
+		tenants = map[CredentialKind][]string{}
+	}
+	for _, kind := range p.Credentials {
+		var id CredentialIDs
+		switch kind {
+		case CredentialKindOnBehalfOf:
+			id = CredentialIDs{ClientID: p.OnBehalfOfClientID, TenantID: p.OnBehalfOfTenantID}
+		case CredentialKindWorkloadIdentity:
+			id = CredentialIDs{ClientID: p.WorkloadIdentityClientID, TenantID: p.WorkloadIdentityTenantID}
+		case CredentialKindClientAssertion:
+			id = CredentialIDs{ClientID: p.ClientAssertionClientID, TenantID: p.ClientAssertionTenantID}
+		case CredentialKindManagedIdentity:
+			id = CredentialIDs{ClientID: p.ManagedIdentityClientID}
+		case CredentialKindAzureCLI:
+			id = CredentialIDs{TenantID: p.AzureCLITenantID}
+		}
+		if id != (CredentialIDs{}) {
+			ids[kind] = id
+		}
+		if _, ok := tenants[kind]; !ok && id.TenantID != "" && p.AdditionallyAllowedTenants != nil {
+			tenants[kind] = slices.Clone(p.AdditionallyAllowedTenants)
+		}
+	}
+	o.CredentialIdentity = ids
+	o.AdditionallyAllowedTenantsByCredential = tenants
+
+	o.OnBehalfOfClientID, o.OnBehalfOfTenantID = p.OnBehalfOfClientID, p.OnBehalfOfTenantID
+	o.ClientAssertionClientID, o.ClientAssertionTenantID = p.ClientAssertionClientID, p.ClientAssertionTenantID
+	if o.OnBehalfOfClientSecret != "" || p.oboSecret != "" {
+		o.OnBehalfOfClientSecret = redacted
+	}
+	if o.BrokerSecret != "" {
+		o.BrokerSecret = redacted
+	}
+	if o.ExtraHeaders != nil {
+		headers := make(map[string]string, len(o.ExtraHeaders))
+		for k := range o.ExtraHeaders {
+			headers[k] = redacted
+		}
+		o.ExtraHeaders = headers
+	}
+	return o
+}
+
+// effectiveCloud returns the cloud the credentials of the plan authenticate in, the one of the ClientOptions, or
+// else the one of AZURE_AUTHORITY_HOST, as azidentity uses it.
+func (p *Plan) effectiveCloud() cloud.Configuration {
+	if p.options.Cloud.ActiveDirectoryAuthorityHost != "" {
+		return p.options.Cloud
+	}
+	host := p.authorityHost
+	if host == "" {
+		return cloud.AzurePublic
+	}
+	for _, c := range []cloud.Configuration{cloud.AzurePublic, cloud.AzureChina, cloud.AzureGovernment} {
+		if normalizeAuthorityHost(c.ActiveDirectoryAuthorityHost) == normalizeAuthorityHost(host) {
+			return c
+		}
+	}
+	return cloud.Configuration{ActiveDirectoryAuthorityHost: host}
+}
+
+// normalizeAuthorityHost returns the authority host in the form of the hosts of the known clouds, i.e. lowercase
+// with a trailing slash, e.g. https://login.microsoftonline.com/ for https://LOGIN.microsoftonline.com.
+func normalizeAuthorityHost(host string) string {
+	return strings.ToLower(strings.TrimSuffix(host, "/")) + "/"
+}
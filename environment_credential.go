@@ -0,0 +1,49 @@
+package azidentityext
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+)
+
+// newCertificateEnvironmentCredential creates the credential of the certificate configured by the environment, for
+// DefaultAzureCredentialOptions.PreferCertificateAuth, reading the same variables as azidentity.EnvironmentCredential.
+// It returns a nil credential when the environment doesn't configure both a secret and a certificate, the
+// EnvironmentCredential then being used as is.
+func (p *Plan) newCertificateEnvironmentCredential(clientOptions azcore.ClientOptions) (azcore.TokenCredential, error) {
+	// azidentity reads these from the process environment, which is thus read here too
+	certPath := os.Getenv("AZURE_CLIENT_CERTIFICATE_PATH")
+	if certPath == "" || os.Getenv("AZURE_CLIENT_SECRET") == "" {
+		return nil, nil
+	}
+	tenantID, clientID := os.Getenv("AZURE_TENANT_ID"), os.Getenv("AZURE_CLIENT_ID")
+	if tenantID == "" || clientID == "" {
+		return nil, errors.New("missing environment variable AZURE_TENANT_ID or AZURE_CLIENT_ID")
+	}
+	certData, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate file %q: %v", certPath, err)
+	}
+	var password []byte
+	if v := os.Getenv("AZURE_CLIENT_CERTIFICATE_PASSWORD"); v != "" {
+		password = []byte(v)
+	}
+	certs, key, err := azidentity.ParseCertificates(certData, password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certificate from %q: %v", certPath, err)
+	}
+	o := &azidentity.ClientCertificateCredentialOptions{
+		AdditionallyAllowedTenants: p.additionallyAllowedTenants(CredentialKindEnvironment),
+		ClientOptions:              clientOptions,
+		DisableInstanceDiscovery:   p.options.DisableInstanceDiscovery,
+	}
+	if v, ok := os.LookupEnv("AZURE_CLIENT_SEND_CERTIFICATE_CHAIN"); ok {
+		o.SendCertificateChain = v == "1" || strings.ToLower(v) == "true"
+	}
+	p.options.logf("%s: authenticating with the certificate of AZURE_CLIENT_CERTIFICATE_PATH, ignoring AZURE_CLIENT_SECRET as PreferCertificateAuth is set", CredentialKindEnvironment)
+	return azidentity.NewClientCertificateCredential(tenantID, clientID, certs, key, o)
+}
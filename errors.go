@@ -0,0 +1,65 @@
+package azidentityext
+
+import (
+	"errors"
+	"reflect"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+)
+
+// chainError is returned by DefaultAzureCredential.GetToken when no credential in the chain provided a token.
+// Its message is the one of the underlying ChainedTokenCredential, while Unwrap returns the error each attempted
+// credential returned, so that callers can use errors.As to retrieve a specific azidentity error type.
+type chainError struct {
+	err  error
+	errs []error
+}
+
+func (e *chainError) Error() string {
+	return redact(e.err.Error())
+}
+
+func (e *chainError) Unwrap() []error {
+	return e.errs
+}
+
+// credentialUnavailableError indicates a credential can't attempt authentication, so that the chain moves on to
+// its next credential.
+//
+// azidentity.ChainedTokenCredential only does so for its own unexported credentialUnavailableError type, so As
+// also matches targets of that type, setting them to a zero value of it.
+type credentialUnavailableError struct {
+	msg string
+	// err, if set, is the cause of the credential being unavailable, for errors.Is
+	err error
+}
+
+func newCredentialUnavailableError(credType, message string) error {
+	return &credentialUnavailableError{msg: credType + ": " + message}
+}
+
+func (e *credentialUnavailableError) Error() string {
+	return e.msg
+}
+
+func (e *credentialUnavailableError) Unwrap() error {
+	return e.err
+}
+
+func (e *credentialUnavailableError) As(target any) bool {
+	v := reflect.ValueOf(target)
+	if v.Kind() != reflect.Pointer || v.IsNil() {
+		return false
+	}
+	t := v.Type().Elem()
+	if t.Kind() != reflect.Pointer || t.Elem().PkgPath() != azidentityPkgPath || t.Elem().Name() != "credentialUnavailableError" {
+		return false
+	}
+	v.Elem().Set(reflect.New(t.Elem()))
+	return true
+}
+
+var azidentityPkgPath = reflect.TypeOf(azidentity.ChainedTokenCredential{}).PkgPath()
+
+// ErrOfflineNoToken is returned by a GetToken called with an OfflineOnly context when no unexpired token is cached.
+var ErrOfflineNoToken = errors.New("no unexpired token is cached for this offline request")
@@ -0,0 +1,47 @@
+package azidentityext
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// reservedHeaders are the headers ExtraHeaders can't set, as the credentials and the pipeline set them, in their
+// canonical form.
+var reservedHeaders = map[string]bool{
+	"Authorization":     true,
+	"Content-Length":    true,
+	"Content-Type":      true,
+	"Host":              true,
+	"Metadata":          true,
+	"X-Identity-Header": true,
+	"Secret":            true,
+	"X-Broker-Secret":   true,
+}
+
+// extraHeadersPolicy adds the DefaultAzureCredentialOptions.ExtraHeaders to the requests of the credentials.
+type extraHeadersPolicy struct {
+	headers map[string]string
+}
+
+// newExtraHeadersPolicy validates the headers, which must not include any reserved header, nor the brokerSecretHeader
+// carrying the secret of the BrokerCredential, when set.
+func newExtraHeadersPolicy(headers map[string]string, brokerSecretHeader string) (*extraHeadersPolicy, error) {
+	canonical := make(map[string]string, len(headers))
+	for k, v := range headers {
+		k = http.CanonicalHeaderKey(k)
+		if reservedHeaders[k] || brokerSecretHeader != "" && k == http.CanonicalHeaderKey(brokerSecretHeader) {
+			return nil, fmt.Errorf("ExtraHeaders can't set the reserved header %s", k)
+		}
+		canonical[k] = v
+	}
+	return &extraHeadersPolicy{headers: canonical}, nil
+}
+
+func (p *extraHeadersPolicy) Do(req *policy.Request) (*http.Response, error) {
+	for k, v := range p.headers {
+		req.Raw().Header.Set(k, v)
+	}
+	return req.Next()
+}
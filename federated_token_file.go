@@ -0,0 +1,58 @@
+package azidentityext
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+const (
+	// federatedTokenFileRetries and federatedTokenFileRetryDelay bound the wait for an empty federated token file
+	// to be written.
+	federatedTokenFileRetries    = 5
+	federatedTokenFileRetryDelay = 200 * time.Millisecond
+)
+
+// ErrFederatedTokenNotReady is wrapped by the error of the WorkloadIdentityCredential when the federated token file
+// exists but is empty, e.g. at pod startup, before the kubelet wrote the projected service account token.
+var ErrFederatedTokenNotReady = errors.New("the federated token file is empty, its token isn't written yet")
+
+// federatedTokenFileCredential wraps the WorkloadIdentityCredential, to wait briefly for its federated token file
+// to be written when it's empty, as azidentity would otherwise send an empty assertion, failing with a
+// cryptic error from Azure AD.
+type federatedTokenFileCredential struct {
+	cred azcore.TokenCredential
+	file string
+	// retries and delay bound the wait
+	retries int
+	delay   time.Duration
+}
+
+func (c *federatedTokenFileCredential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	for i := 0; ; i++ {
+		b, err := os.ReadFile(c.file)
+		if err != nil || len(bytes.TrimSpace(b)) != 0 {
+			// azidentity reports the read errors
+			break
+		}
+		if i == c.retries {
+			return azcore.AccessToken{}, &credentialUnavailableError{msg: fmt.Sprintf("%s: %v: %s", CredentialKindWorkloadIdentity, ErrFederatedTokenNotReady, c.file), err: ErrFederatedTokenNotReady}
+		}
+		t := time.NewTimer(c.delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return azcore.AccessToken{}, ctx.Err()
+		case <-t.C:
+		}
+	}
+	return c.cred.GetToken(ctx, opts)
+}
+
+var _ azcore.TokenCredential = (*federatedTokenFileCredential)(nil)
@@ -0,0 +1,48 @@
+package azidentityext
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// getTokensConcurrency bounds the number of tokens GetTokens acquires at the same time.
+const getTokensConcurrency = 4
+
+// GetTokens requests an access token for each of the requests, concurrently. The returned tokens are aligned with
+// the requests. If any of the requests fails, the returned error joins the errors of the failed requests, each
+// prefixed with the index of its request, and the tokens of the failed requests are zero values.
+// Like the sequential calls of GetToken, the requests share the successful credential memoized by the chain.
+// When ctx is already done, its error is returned right away, without attempting any request.
+func (c *DefaultAzureCredential) GetTokens(ctx context.Context, requests []policy.TokenRequestOptions) ([]azcore.AccessToken, error) {
+	tokens := make([]azcore.AccessToken, len(requests))
+	if err := ctx.Err(); err != nil {
+		return tokens, err
+	}
+	errs := make([]error, len(requests))
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, getTokensConcurrency)
+	for i, req := range requests {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, req policy.TokenRequestOptions) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			tk, err := c.GetToken(ctx, req)
+			if err != nil {
+				errs[i] = fmt.Errorf("request %d: %w", i, err)
+				return
+			}
+			tokens[i] = tk
+		}(i, req)
+	}
+	wg.Wait()
+	return tokens, errors.Join(errs...)
+}
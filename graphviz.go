@@ -0,0 +1,50 @@
+package azidentityext
+
+import (
+	"fmt"
+	"strings"
+)
+
+// GraphViz returns a Graphviz DOT description of the chain: GetToken attempts the credentials in order, falling
+// back to the next one when a credential is unavailable, and fails when the last one is. Credentials injected by
+// the Credentials option are drawn dashed, and the credentials limited by ScopePolicy are labeled with their
+// scopes. It only renders the chain as built, e.g. the credential persisted by CredentialHintFile isn't shown.
+func (c *DefaultAzureCredential) GraphViz() string {
+	var b strings.Builder
+	b.WriteString("digraph DefaultAzureCredential {\n")
+	b.WriteString("\trankdir=LR;\n")
+	b.WriteString("\tnode [shape=box];\n")
+	b.WriteString("\tstart [label=\"GetToken\", shape=oval];\n")
+	b.WriteString("\tfailed [label=\"error\", shape=oval];\n")
+	prev := "start"
+	for i, w := range c.state.Load().credentials {
+		id := fmt.Sprintf("c%d", i)
+		label := dotEscape(w.name)
+		if w.allowedScopes != nil {
+			label += `\nscopes: ` + dotEscape(strings.Join(w.allowedScopes, ", "))
+		}
+		attrs := ""
+		if w.kind == "" {
+			attrs = ", style=dashed"
+		}
+		fmt.Fprintf(&b, "\t%s [label=\"%s\"%s];\n", id, label, attrs)
+		if prev == "start" {
+			fmt.Fprintf(&b, "\t%s -> %s;\n", prev, id)
+		} else {
+			fmt.Fprintf(&b, "\t%s -> %s [label=\"unavailable\"];\n", prev, id)
+		}
+		prev = id
+	}
+	if prev == "start" {
+		b.WriteString("\tstart -> failed;\n")
+	} else {
+		fmt.Fprintf(&b, "\t%s -> failed [label=\"unavailable\"];\n", prev)
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// dotEscape escapes s for a DOT string.
+func dotEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s)
+}
@@ -0,0 +1,90 @@
+package azidentityext
+
+import (
+	"os"
+	"strings"
+	"sync"
+)
+
+// credentialHint implements DefaultAzureCredentialOptions.CredentialHintFile and the CredentialState.
+// Without a hint file, it is only set by ImportState.
+type credentialHint struct {
+	file  string
+	creds []*wrappedCredential
+	logf  func(format string, a ...any)
+
+	mu sync.Mutex
+	// preferred is the credential named by the hint file, attempted before the chain until it fails.
+	preferred *wrappedCredential
+	// written is the credential name currently in the hint file.
+	written string
+}
+
+// newCredentialHint reads the hint file, ignoring a missing file or one not naming any of creds.
+func newCredentialHint(file string, creds []*wrappedCredential, logf func(format string, a ...any)) *credentialHint {
+	h := &credentialHint{file: file, creds: creds, logf: logf}
+	if file == "" {
+		return h
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logf("ignoring the credential hint file: %v", err)
+		}
+		return h
+	}
+	name := strings.TrimSpace(string(b))
+	if cred := h.credential(name); cred != nil {
+		h.preferred = cred
+		h.written = name
+		return h
+	}
+	logf("ignoring the credential hint file %s, it doesn't name any credential of the chain", file)
+	return h
+}
+
+// credential returns the credential of the chain with the name, or nil.
+func (h *credentialHint) credential(name string) *wrappedCredential {
+	for _, cred := range h.creds {
+		if cred.name == name {
+			return cred
+		}
+	}
+	return nil
+}
+
+// prefer attempts cred before the chain, until it fails.
+func (h *credentialHint) prefer(cred *wrappedCredential) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.preferred = cred
+}
+
+func (h *credentialHint) preferredCredential() *wrappedCredential {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.preferred
+}
+
+// drop stops attempting cred before the chain, as it failed.
+func (h *credentialHint) drop(cred *wrappedCredential) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.preferred == cred {
+		h.preferred = nil
+	}
+}
+
+// record writes name to the hint file, if not already there.
+func (h *credentialHint) record(name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.file == "" || name == "" || name == h.written {
+		return
+	}
+	if err := os.WriteFile(h.file, []byte(name+"\n"), 0o600); err != nil {
+		h.logf("failed to write the credential hint file: %v", err)
+		return
+	}
+	h.written = name
+}
@@ -0,0 +1,174 @@
+package azidentityext
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+)
+
+// ManagedIdentitySource identifies the hosting environment whose managed identity endpoint is used by the
+// ManagedIdentityCredential. It is only meant for diagnostics, the endpoint selection itself is done by azidentity.
+type ManagedIdentitySource string
+
+const (
+	ManagedIdentitySourceIMDS          ManagedIdentitySource = "IMDS"
+	ManagedIdentitySourceAppService    ManagedIdentitySource = "AppService"
+	ManagedIdentitySourceServiceFabric ManagedIdentitySource = "ServiceFabric"
+	ManagedIdentitySourceAzureArc      ManagedIdentitySource = "AzureArc"
+	ManagedIdentitySourceCloudShell    ManagedIdentitySource = "CloudShell"
+	ManagedIdentitySourceContainerApps ManagedIdentitySource = "ContainerApps"
+)
+
+const (
+	envIdentityEndpoint         = "IDENTITY_ENDPOINT"
+	envIdentityHeader           = "IDENTITY_HEADER"
+	envIdentityServerThumbprint = "IDENTITY_SERVER_THUMBPRINT"
+	envArcIMDSEndpoint          = "IMDS_ENDPOINT"
+	envMSIEndpoint              = "MSI_ENDPOINT"
+	envMSISecret                = "MSI_SECRET"
+	envContainerAppName         = "CONTAINER_APP_NAME"
+)
+
+// DetectManagedIdentitySource returns the managed identity environment detected from the process environment,
+// following the same rules as azidentity:
+//
+//   - Service Fabric: IDENTITY_ENDPOINT, IDENTITY_HEADER and IDENTITY_SERVER_THUMBPRINT are all set
+//   - Container Apps: IDENTITY_ENDPOINT and IDENTITY_HEADER are set, along with CONTAINER_APP_NAME, which Azure
+//     Container Apps sets in its containers
+//   - App Service: IDENTITY_ENDPOINT and IDENTITY_HEADER are set, which is also the case in Azure Functions
+//   - Azure Arc: IDENTITY_ENDPOINT and IMDS_ENDPOINT are set, as done by the agent of Arc-enabled servers. Its
+//     endpoint authenticates requests with a challenge, whose key is a file under /var/opt/azcmagent/tokens on
+//     Linux, or %ProgramData%\AzureConnectedMachineAgent\Tokens on Windows, which only root or the members of the
+//     himds group, respectively of the Administrators group, can read. Only the system-assigned identity is
+//     supported.
+//   - Cloud Shell: MSI_ENDPOINT is set
+//   - IMDS: otherwise, including in containers running on VMs, e.g. Docker on a VM or Azure Container Instances,
+//     which have no distinct endpoint, IMDS having to be reachable from the network of the container
+func DetectManagedIdentitySource() ManagedIdentitySource {
+	return detectManagedIdentitySource(os.LookupEnv)
+}
+
+func detectManagedIdentitySource(lookupEnv func(string) (string, bool)) ManagedIdentitySource {
+	_, hasEndpoint := lookupEnv(envIdentityEndpoint)
+	_, hasHeader := lookupEnv(envIdentityHeader)
+	_, hasThumbprint := lookupEnv(envIdentityServerThumbprint)
+	_, hasArcEndpoint := lookupEnv(envArcIMDSEndpoint)
+	_, hasMSIEndpoint := lookupEnv(envMSIEndpoint)
+	_, hasContainerApp := lookupEnv(envContainerAppName)
+
+	switch {
+	case hasEndpoint && hasHeader && hasThumbprint:
+		return ManagedIdentitySourceServiceFabric
+	case hasEndpoint && hasHeader && hasContainerApp:
+		return ManagedIdentitySourceContainerApps
+	case hasEndpoint && hasHeader:
+		return ManagedIdentitySourceAppService
+	case hasEndpoint && hasArcEndpoint:
+		return ManagedIdentitySourceAzureArc
+	case !hasEndpoint && hasMSIEndpoint:
+		return ManagedIdentitySourceCloudShell
+	default:
+		return ManagedIdentitySourceIMDS
+	}
+}
+
+// managedIdentityEndpoint returns the endpoint the managed identity credential requests its tokens from, i.e. that
+// of the ManagedIdentityEndpoint or ManagedIdentityTransport option, or else that of the detected environment.
+func (p *Plan) managedIdentityEndpoint() string {
+	endpoint := p.miDetectedEndpoint
+	if p.miOverridden {
+		endpoint = p.miEndpoint
+	}
+	if endpoint == "" && p.ManagedIdentitySource == ManagedIdentitySourceIMDS {
+		return imdsEndpoint
+	}
+	return endpoint
+}
+
+// validateManagedIdentityEnv reports a managed identity environment that is only partially configured, which
+// azidentity would silently treat as IMDS, or as Cloud Shell for the legacy App Service variables, leading to
+// confusing failures when requesting a token. Neither variable being set is valid, IMDS is then probed.
+func validateManagedIdentityEnv(lookupEnv func(string) (string, bool)) error {
+	_, hasEndpoint := lookupEnv(envIdentityEndpoint)
+	_, hasHeader := lookupEnv(envIdentityHeader)
+	_, hasThumbprint := lookupEnv(envIdentityServerThumbprint)
+	_, hasArcEndpoint := lookupEnv(envArcIMDSEndpoint)
+	_, hasMSIEndpoint := lookupEnv(envMSIEndpoint)
+	_, hasMSISecret := lookupEnv(envMSISecret)
+
+	switch {
+	case hasEndpoint && !hasHeader && !hasArcEndpoint:
+		if hasThumbprint {
+			return errors.New("IDENTITY_ENDPOINT and IDENTITY_SERVER_THUMBPRINT set without IDENTITY_HEADER, set it for Service Fabric")
+		}
+		return errors.New("IDENTITY_ENDPOINT set without IDENTITY_HEADER (App Service, Service Fabric) or IMDS_ENDPOINT (Azure Arc)")
+	case !hasEndpoint && hasHeader:
+		return errors.New("IDENTITY_HEADER set without IDENTITY_ENDPOINT")
+	case !hasEndpoint && hasArcEndpoint:
+		return errors.New("IMDS_ENDPOINT set without IDENTITY_ENDPOINT, set both for Azure Arc")
+	case !hasEndpoint && hasMSISecret:
+		if hasMSIEndpoint {
+			return errors.New("MSI_ENDPOINT and MSI_SECRET set without IDENTITY_ENDPOINT and IDENTITY_HEADER, the legacy App Service variables aren't supported")
+		}
+		return errors.New("MSI_SECRET set without MSI_ENDPOINT")
+	}
+	return nil
+}
+
+// imdsCredential wraps a ManagedIdentityCredential using IMDS, to remember for a while that IMDS is unreachable,
+// instead of probing it again on each request, which takes long when not running in Azure.
+type imdsCredential struct {
+	cred azcore.TokenCredential
+	ttl  time.Duration
+	now  func() time.Time
+	// state is shared by the imdsCredentials of a chain, one per ManagedIdentityClientIDs, so that they all skip
+	// IMDS once one of them found it unreachable
+	state *imdsState
+}
+
+// imdsState is the reachability of IMDS.
+type imdsState struct {
+	mu               sync.Mutex
+	unavailableUntil time.Time
+}
+
+func (c *imdsCredential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	c.state.mu.Lock()
+	until := c.state.unavailableUntil
+	c.state.mu.Unlock()
+	if c.now().Before(until) {
+		return azcore.AccessToken{}, newCredentialUnavailableError(string(CredentialKindManagedIdentity), fmt.Sprintf("IMDS was unreachable, not probing it again until %s", until.Format(time.RFC3339)))
+	}
+
+	tk, err := c.cred.GetToken(ctx, opts)
+	if err != nil && imdsUnreachable(ctx, err) {
+		c.state.mu.Lock()
+		c.state.unavailableUntil = c.now().Add(c.ttl)
+		c.state.mu.Unlock()
+		return tk, newCredentialUnavailableError(string(CredentialKindManagedIdentity), "IMDS is unreachable: "+err.Error())
+	}
+	return tk, err
+}
+
+// imdsUnreachable tells whether err is caused by IMDS not responding, rather than by the caller's context or by
+// an error response of IMDS.
+func imdsUnreachable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var (
+		authErr *azidentity.AuthenticationFailedError
+		urlErr  *url.Error
+	)
+	return errors.As(err, &authErr) && authErr.RawResponse == nil || errors.As(err, &urlErr)
+}
+
+var _ azcore.TokenCredential = (*imdsCredential)(nil)
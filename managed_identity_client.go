@@ -0,0 +1,243 @@
+package azidentityext
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	goruntime "runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+)
+
+const (
+	imdsEndpoint         = "http://169.254.169.254/metadata/identity/oauth2/token"
+	imdsAPIVersion       = "2018-02-01"
+	appServiceAPIVersion = "2019-08-01"
+	arcAPIVersion        = "2019-11-01"
+	defaultScopeSuffix   = "/.default"
+
+	// arcKeyDirLinux and arcKeyDirWindows, under %ProgramData%, are where the Azure Arc agent writes the keys of
+	// its challenges, which are at most arcMaxKeySize bytes
+	arcKeyDirLinux   = "/var/opt/azcmagent/tokens"
+	arcKeyDirWindows = `AzureConnectedMachineAgent\Tokens`
+	arcMaxKeySize    = 4096
+)
+
+// managedIdentityClient acquires managed identity tokens from an explicitly selected endpoint. azidentity selects
+// the endpoint from the process environment only, so this is used when that selection is overridden, e.g. by
+// DefaultAzureCredentialOptions.ManagedIdentityTransport. Only the IMDS, App Service, which Container Apps
+// shares, and Azure Arc protocols are supported.
+type managedIdentityClient struct {
+	source   ManagedIdentitySource
+	endpoint string
+	// header is the secret sent in the X-IDENTITY-HEADER of the App Service requests
+	header   string
+	clientID string
+	pipeline runtime.Pipeline
+}
+
+func newManagedIdentityClient(source ManagedIdentitySource, endpoint, header, clientID string, options *azcore.ClientOptions) (*managedIdentityClient, error) {
+	switch source {
+	case ManagedIdentitySourceIMDS:
+		if endpoint == "" {
+			endpoint = imdsEndpoint
+		}
+		o := *options
+		setIMDSRetryDefaults(&o.Retry)
+		options = &o
+	case ManagedIdentitySourceAppService, ManagedIdentitySourceContainerApps:
+		if endpoint == "" || header == "" {
+			return nil, fmt.Errorf("the %s managed identity requires IDENTITY_ENDPOINT and IDENTITY_HEADER", source)
+		}
+	case ManagedIdentitySourceAzureArc:
+		if endpoint == "" {
+			return nil, errors.New("the Azure Arc managed identity requires IDENTITY_ENDPOINT")
+		}
+		// Azure Arc only supports the system-assigned identity, and fails the requests for a user-assigned one
+		clientID = ""
+	default:
+		return nil, fmt.Errorf("the %s managed identity transport can't be selected explicitly", source)
+	}
+	return &managedIdentityClient{
+		source:   source,
+		endpoint: endpoint,
+		header:   header,
+		clientID: clientID,
+		pipeline: runtime.NewPipeline(component, version, runtime.PipelineOptions{}, options),
+	}, nil
+}
+
+// setIMDSRetryDefaults sets the zero valued retry options to the defaults azidentity applies to IMDS, which
+// recommends retrying 404, 410, 429 and 5xx responses.
+func setIMDSRetryDefaults(o *policy.RetryOptions) {
+	if o.MaxRetries == 0 {
+		o.MaxRetries = 5
+	}
+	if o.MaxRetryDelay == 0 {
+		o.MaxRetryDelay = time.Minute
+	}
+	if o.RetryDelay == 0 {
+		o.RetryDelay = 2 * time.Second
+	}
+	if o.StatusCodes == nil {
+		o.StatusCodes = []int{
+			http.StatusNotFound,
+			http.StatusGone,
+			http.StatusTooManyRequests,
+			http.StatusInternalServerError,
+			http.StatusNotImplemented,
+			http.StatusBadGateway,
+			http.StatusServiceUnavailable,
+			http.StatusGatewayTimeout,
+			http.StatusHTTPVersionNotSupported,
+			http.StatusVariantAlsoNegotiates,
+			http.StatusInsufficientStorage,
+			http.StatusLoopDetected,
+			http.StatusNotExtended,
+			http.StatusNetworkAuthenticationRequired,
+		}
+	}
+	if o.TryTimeout == 0 {
+		o.TryTimeout = time.Minute
+	}
+}
+
+func (c *managedIdentityClient) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	name := string(CredentialKindManagedIdentity)
+	if len(opts.Scopes) != 1 {
+		return azcore.AccessToken{}, fmt.Errorf("%s: GetToken() requires exactly one scope", name)
+	}
+	resource := strings.TrimSuffix(opts.Scopes[0], defaultScopeSuffix)
+	req, err := c.newRequest(ctx, resource)
+	if err != nil {
+		return azcore.AccessToken{}, fmt.Errorf("%s: %v", name, err)
+	}
+	if c.source == ManagedIdentitySourceAzureArc {
+		key, err := c.arcChallengeKey(ctx, resource)
+		if err != nil {
+			return azcore.AccessToken{}, fmt.Errorf("%s: %w", name, err)
+		}
+		req.Raw().Header.Set("Authorization", "Basic "+key)
+	}
+	resp, err := c.pipeline.Do(req)
+	if err != nil {
+		return azcore.AccessToken{}, fmt.Errorf("%s: %w", name, err)
+	}
+	if c.source == ManagedIdentitySourceIMDS && resp.StatusCode == http.StatusBadRequest {
+		// as with azidentity, IMDS responds 400 when the requested identity isn't assigned, so that the chain moves
+		// on to its next credential, e.g. the next of ManagedIdentityClientIDs
+		return azcore.AccessToken{}, &credentialUnavailableError{msg: name + ": the requested identity isn't assigned to this resource", err: &redactedError{err: runtime.NewResponseError(resp)}}
+	}
+	if !runtime.HasStatusCode(resp, http.StatusOK) {
+		return azcore.AccessToken{}, fmt.Errorf("%s: %w", name, &redactedError{err: runtime.NewResponseError(resp)})
+	}
+	var v struct {
+		Token     string      `json:"access_token"`
+		ExpiresIn json.Number `json:"expires_in"`
+		ExpiresOn json.Number `json:"expires_on"`
+	}
+	if err := runtime.UnmarshalAsJSON(resp, &v); err != nil {
+		return azcore.AccessToken{}, fmt.Errorf("%s: malformed response: %v", name, err)
+	}
+	if v.ExpiresOn != "" {
+		if expiresOn, err := strconv.ParseInt(v.ExpiresOn.String(), 10, 64); err == nil {
+			return azcore.AccessToken{Token: v.Token, ExpiresOn: time.Unix(expiresOn, 0).UTC()}, nil
+		}
+	}
+	if expiresIn, err := v.ExpiresIn.Int64(); err == nil {
+		return azcore.AccessToken{Token: v.Token, ExpiresOn: time.Now().Add(time.Duration(expiresIn) * time.Second).UTC()}, nil
+	}
+	return azcore.AccessToken{}, fmt.Errorf("%s: malformed response: unexpected expires_on %q", name, v.ExpiresOn)
+}
+
+// newRequest creates the token request for resource, following the protocol of the source.
+func (c *managedIdentityClient) newRequest(ctx context.Context, resource string) (*policy.Request, error) {
+	req, err := runtime.NewRequest(ctx, http.MethodGet, c.endpoint)
+	if err != nil {
+		return nil, err
+	}
+	q := req.Raw().URL.Query()
+	q.Add("resource", resource)
+	if c.clientID != "" {
+		q.Add("client_id", c.clientID)
+	}
+	switch c.source {
+	case ManagedIdentitySourceIMDS:
+		req.Raw().Header.Set("Metadata", "true")
+		q.Add("api-version", imdsAPIVersion)
+	case ManagedIdentitySourceAppService, ManagedIdentitySourceContainerApps:
+		req.Raw().Header.Set("X-IDENTITY-HEADER", c.header)
+		q.Add("api-version", appServiceAPIVersion)
+	case ManagedIdentitySourceAzureArc:
+		req.Raw().Header.Set("Metadata", "true")
+		q.Add("api-version", arcAPIVersion)
+	}
+	req.Raw().URL.RawQuery = q.Encode()
+	return req, nil
+}
+
+// arcChallengeKey runs the challenge of the Azure Arc identity endpoint: an unauthenticated token request is
+// answered 401, with a WWW-Authenticate header naming a file only readable by privileged users, whose content
+// authenticates the actual token request.
+func (c *managedIdentityClient) arcChallengeKey(ctx context.Context, resource string) (string, error) {
+	req, err := c.newRequest(ctx, resource)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.pipeline.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		return "", fmt.Errorf("expected a 401 response to the Azure Arc challenge request, got %d", resp.StatusCode)
+	}
+	// Basic realm=<path of the key file>
+	header := resp.Header.Get("WWW-Authenticate")
+	i := strings.LastIndex(header, "=")
+	if i == -1 {
+		return "", fmt.Errorf("unexpected WWW-Authenticate header %q in the Azure Arc challenge response", header)
+	}
+	path := header[i+1:]
+	if err := validateArcKeyPath(path); err != nil {
+		return "", err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("reading the Azure Arc challenge key file: %v", err)
+	}
+	if info.Size() > arcMaxKeySize {
+		return "", fmt.Errorf("the Azure Arc challenge key file %s is larger than %d bytes", path, arcMaxKeySize)
+	}
+	key, err := os.ReadFile(path)
+	if err != nil {
+		// reading the file requires being root, or a member of the himds group on Linux or of the administrators
+		// group on Windows
+		return "", fmt.Errorf("reading the Azure Arc challenge key file, which requires membership of the himds (Linux) or Administrators (Windows) group: %v", err)
+	}
+	return string(key), nil
+}
+
+// validateArcKeyPath checks that the challenge key file named by the Azure Arc endpoint is where the Arc agent
+// writes them, so that a rogue endpoint can't make this process disclose the content of an arbitrary file.
+func validateArcKeyPath(path string) error {
+	dir := arcKeyDirLinux
+	if goruntime.GOOS == "windows" {
+		dir = filepath.Join(os.Getenv("ProgramData"), arcKeyDirWindows)
+	}
+	if filepath.Dir(filepath.Clean(path)) != dir || filepath.Ext(path) != ".key" {
+		return fmt.Errorf("unexpected Azure Arc challenge key file %q, expected a .key file in %s", path, dir)
+	}
+	return nil
+}
+
+var _ azcore.TokenCredential = (*managedIdentityClient)(nil)
@@ -0,0 +1,32 @@
+package azidentityext
+
+import "time"
+
+// MetricsSink receives the metrics of the token acquisitions of a DefaultAzureCredential, e.g. to adapt them to
+// Prometheus or OpenTelemetry. The name passed to its methods is the name of the credential of the chain.
+// Implementations must be safe for concurrent use, and should embed NopMetricsSink to keep compiling when
+// methods are added to this interface.
+type MetricsSink interface {
+	// Attempt is called before a credential attempts to acquire a token.
+	Attempt(name string)
+	// Success is called after a credential acquired a token, with the duration of the acquisition.
+	Success(name string, d time.Duration)
+	// Failure is called after a credential failed to acquire a token, with the duration of the acquisition.
+	Failure(name string, d time.Duration, err error)
+	// CacheHit is called when a token is served by the token cache, with the key of the token.
+	CacheHit(key string)
+	// ConstructionFailure is called when NewDefaultAzureCredential fails to build a credential of the chain, with the
+	// error it returns, once per error returned in its credErrors.
+	ConstructionFailure(name string, err error)
+}
+
+// NopMetricsSink is a MetricsSink discarding all the metrics.
+type NopMetricsSink struct{}
+
+func (NopMetricsSink) Attempt(string)                       {}
+func (NopMetricsSink) Success(string, time.Duration)        {}
+func (NopMetricsSink) Failure(string, time.Duration, error) {}
+func (NopMetricsSink) CacheHit(string)                      {}
+func (NopMetricsSink) ConstructionFailure(string, error)    {}
+
+var _ MetricsSink = NopMetricsSink{}
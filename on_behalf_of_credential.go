@@ -0,0 +1,81 @@
+package azidentityext
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+)
+
+type userAssertionKey struct{}
+
+// userAssertion is the user assertion of a GetToken call, for the on-behalf-of credential.
+type userAssertion struct {
+	value string
+	err   error
+}
+
+// WithUserAssertion returns a context that makes a GetToken called with it exchange the specified user assertion,
+// i.e. the access token the caller received from its user, by the on-behalf-of credential.
+// It takes precedence over DefaultAzureCredentialOptions.GetUserAssertion.
+func WithUserAssertion(ctx context.Context, assertion string) context.Context {
+	return context.WithValue(ctx, userAssertionKey{}, userAssertion{value: assertion})
+}
+
+func userAssertionFromContext(ctx context.Context) (userAssertion, bool) {
+	ua, ok := ctx.Value(userAssertionKey{}).(userAssertion)
+	return ua, ok
+}
+
+// resolveUserAssertion returns a context carrying the user assertion of a GetToken call, obtained from the
+// GetUserAssertion callback unless the context already carries one.
+func (c *DefaultAzureCredential) resolveUserAssertion(ctx context.Context) context.Context {
+	if _, ok := userAssertionFromContext(ctx); ok || c.options.GetUserAssertion == nil {
+		return ctx
+	}
+	v, err := c.options.GetUserAssertion(ctx)
+	return context.WithValue(ctx, userAssertionKey{}, userAssertion{value: v, err: err})
+}
+
+// hashUserAssertion identifies a user assertion in a token cache key, without keeping the assertion itself.
+func hashUserAssertion(ctx context.Context) string {
+	ua, ok := userAssertionFromContext(ctx)
+	if !ok || ua.value == "" {
+		return ""
+	}
+	h := sha256.Sum256([]byte(ua.value))
+	return hex.EncodeToString(h[:])
+}
+
+// onBehalfOfCredential exchanges the user assertion of each GetToken call for a token, by an
+// azidentity.OnBehalfOfCredential created for that assertion. It is unavailable for the calls without assertion.
+type onBehalfOfCredential struct {
+	tenantID string
+	clientID string
+	secret   string
+	options  azidentity.OnBehalfOfCredentialOptions
+}
+
+func (c *onBehalfOfCredential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	name := string(CredentialKindOnBehalfOf)
+	ua, _ := userAssertionFromContext(ctx)
+	if ua.err != nil {
+		// this error isn't a credentialUnavailableError, so that the chain stops
+		return azcore.AccessToken{}, fmt.Errorf("%s: failed to get the user assertion: %w", name, ua.err)
+	}
+	if ua.value == "" {
+		return azcore.AccessToken{}, newCredentialUnavailableError(name, "no user assertion in this request")
+	}
+	options := c.options
+	cred, err := azidentity.NewOnBehalfOfCredentialWithSecret(c.tenantID, c.clientID, ua.value, c.secret, &options)
+	if err != nil {
+		return azcore.AccessToken{}, err
+	}
+	return cred.GetToken(ctx, opts)
+}
+
+var _ azcore.TokenCredential = (*onBehalfOfCredential)(nil)
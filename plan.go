@@ -0,0 +1,786 @@
+package azidentityext
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"os"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+)
+
+// CredentialKind identifies a kind of credential of the chain.
+type CredentialKind string
+
+const (
+	CredentialKindStaticToken      CredentialKind = "StaticTokenCredential"
+	CredentialKindOnBehalfOf       CredentialKind = "OnBehalfOfCredential"
+	CredentialKindBroker           CredentialKind = "BrokerCredential"
+	CredentialKindEnvironment      CredentialKind = "EnvironmentCredential"
+	CredentialKindWorkloadIdentity CredentialKind = "WorkloadIdentityCredential"
+	CredentialKindClientAssertion  CredentialKind = "ClientAssertionCredential"
+	CredentialKindManagedIdentity  CredentialKind = "ManagedIdentityCredential"
+	CredentialKindAzureCLI         CredentialKind = "AzureCLICredential"
+)
+
+// defaultCredentialOrder is the order in which the credentials are attempted.
+var defaultCredentialOrder = []CredentialKind{
+	CredentialKindStaticToken,
+	CredentialKindOnBehalfOf,
+	CredentialKindBroker,
+	CredentialKindEnvironment,
+	CredentialKindWorkloadIdentity,
+	CredentialKindClientAssertion,
+	CredentialKindManagedIdentity,
+	CredentialKindAzureCLI,
+}
+
+// goos is the operating system CredentialOrderByOS is looked up with.
+var goos = runtime.GOOS
+
+// Plan describes the credentials a DefaultAzureCredential is built from, together with the configuration detected
+// from the environment. Creating a Plan does no I/O other than reading environment variables, so it can be inspected
+// and validated before any credential is built.
+type Plan struct {
+	// Credentials are the kinds of the enabled credentials, in the order they will be attempted.
+	Credentials []CredentialKind
+
+	// EnvironmentVariables are the names of the environment variables that were read and set, in the order they were
+	// first read. Their values aren't kept, as some of them are secrets.
+	EnvironmentVariables []string
+
+	// AdditionallyAllowedTenants is read from AZURE_ADDITIONALLY_ALLOWED_TENANTS.
+	AdditionallyAllowedTenants []string
+
+	// ManagedIdentityClientID is the client ID of the user-assigned managed identity, read from AZURE_CLIENT_ID.
+	// An empty value means the system-assigned identity.
+	ManagedIdentityClientID string
+
+	// ManagedIdentitySource is the detected managed identity environment, unless overridden by the
+	// ManagedIdentityTransport option.
+	ManagedIdentitySource ManagedIdentitySource
+
+	// WorkloadIdentityClientID, WorkloadIdentityTenantID and WorkloadIdentityTokenFilePath configure the
+	// WorkloadIdentityCredential, read from AZURE_CLIENT_ID, AZURE_TENANT_ID and AZURE_FEDERATED_TOKEN_FILE.
+	WorkloadIdentityClientID      string
+	WorkloadIdentityTenantID      string
+	WorkloadIdentityTokenFilePath string
+
+	// OnBehalfOfClientID, OnBehalfOfTenantID configure the on-behalf-of credential, defaulting to AZURE_CLIENT_ID
+	// and AZURE_TENANT_ID. Its client secret, defaulting to AZURE_CLIENT_SECRET, isn't exposed.
+	OnBehalfOfClientID string
+	OnBehalfOfTenantID string
+
+	// ClientAssertionClientID, ClientAssertionTenantID configure the client assertion credential, defaulting to
+	// AZURE_CLIENT_ID and AZURE_TENANT_ID.
+	ClientAssertionClientID string
+	ClientAssertionTenantID string
+
+	// AzureCLITenantID is the tenant the AzureCLICredential authenticates in, the TenantID option.
+	AzureCLITenantID string
+
+	options DefaultAzureCredentialOptions
+	now     func() time.Time
+
+	// miErr reports a partially configured managed identity environment.
+	miErr error
+	// miOverridden tells whether the detected managed identity environment is overridden by the
+	// ManagedIdentityTransport option, with miEndpoint and miHeader configuring the selected endpoint.
+	miOverridden bool
+	miEndpoint   string
+	miHeader     string
+	// miDetectedEndpoint is the endpoint of the detected managed identity environment, unless it's IMDS, whose
+	// endpoint is fixed
+	miDetectedEndpoint string
+
+	oboSecret string
+
+	// authorityHost is the value of AZURE_AUTHORITY_HOST, when no cloud is configured by the ClientOptions.
+	authorityHost string
+	// envClientID, envTenantID and envUsername are the values of AZURE_CLIENT_ID, AZURE_TENANT_ID and, with the
+	// EnvironmentCredential, AZURE_USERNAME, whatever the options overriding them.
+	envClientID string
+	envTenantID string
+	envUsername string
+
+	// staticToken and staticTokenExpiry are the values of the environment variables of the StaticTokenCredential.
+	staticToken       string
+	staticTokenExpiry string
+
+	// imds is the IMDS reachability shared by the managed identity credentials built from the plan
+	imds *imdsState
+
+	// skipped are the reasons of the credentials of the order not in Credentials
+	skipped map[CredentialKind]string
+}
+
+// NewPlan creates the Plan of a DefaultAzureCredential. Pass nil for options to accept defaults.
+func NewPlan(options *DefaultAzureCredentialOptions) *Plan {
+	if options == nil {
+		options = &DefaultAzureCredentialOptions{}
+	}
+	p := &Plan{options: *options, now: time.Now}
+
+	order, explicit := defaultCredentialOrder, true
+	if o, ok := options.CredentialOrderByOS[goos]; ok {
+		order = o
+	} else if len(options.CredentialOrder) != 0 {
+		order = options.CredentialOrder
+	} else {
+		explicit = false
+	}
+	skip := func(kind CredentialKind, reason string) {
+		if p.skipped == nil {
+			p.skipped = map[CredentialKind]string{}
+		}
+		p.skipped[kind] = reason
+	}
+	for _, kind := range order {
+		if reason := p.disabledReason(kind); reason != "" {
+			skip(kind, reason)
+			continue
+		}
+		// the EnvironmentCredential has no configuration other than the environment
+		if kind == CredentialKindEnvironment && options.DisableEnvironmentReads && !explicit {
+			skip(kind, "the environment isn't read, as DisableEnvironmentReads is set")
+			continue
+		}
+		if len(options.RequireCapabilities) != 0 {
+			if m, ok := credentialMeta(kind); !ok || !m.HasCapabilities(options.RequireCapabilities...) {
+				skip(kind, "it lacks capabilities required by RequireCapabilities")
+				continue
+			}
+		}
+		if len(options.TargetScopes) != 0 {
+			if reason := targetScopesReason(kind, options); reason != "" {
+				skip(kind, reason)
+				continue
+			}
+		}
+		if options.IncludeCredential != nil && !options.IncludeCredential(kind) {
+			skip(kind, "excluded by IncludeCredential")
+			continue
+		}
+		p.Credentials = append(p.Credentials, kind)
+	}
+
+	lookupEnv := options.EnvLookup
+	if lookupEnv == nil {
+		lookupEnv = os.LookupEnv
+	}
+	if options.DisableEnvironmentReads {
+		lookupEnv = func(string) (string, bool) { return "", false }
+	}
+	envLookup := lookupEnv
+	lookupEnv = func(key string) (string, bool) {
+		v, ok := envLookup(key)
+		if ok && !slices.Contains(p.EnvironmentVariables, key) {
+			p.EnvironmentVariables = append(p.EnvironmentVariables, key)
+		}
+		return v, ok
+	}
+	if v, ok := lookupEnv("AZURE_ADDITIONALLY_ALLOWED_TENANTS"); ok {
+		p.AdditionallyAllowedTenants = strings.Split(v, ";")
+	}
+	if v, ok := lookupEnv("AZURE_CLIENT_ID"); ok {
+		p.ManagedIdentityClientID = v
+		p.WorkloadIdentityClientID = v
+		p.envClientID = v
+	}
+	if v, ok := lookupEnv("AZURE_TENANT_ID"); ok {
+		p.WorkloadIdentityTenantID, p.envTenantID = v, v
+	}
+	if containsKind(p.Credentials, CredentialKindEnvironment) {
+		p.envUsername, _ = lookupEnv("AZURE_USERNAME")
+	}
+	if v, ok := lookupEnv("AZURE_FEDERATED_TOKEN_FILE"); ok {
+		p.WorkloadIdentityTokenFilePath = v
+	}
+	p.OnBehalfOfClientID, p.OnBehalfOfTenantID, p.oboSecret = options.OnBehalfOfClientID, options.OnBehalfOfTenantID, options.OnBehalfOfClientSecret
+	if p.OnBehalfOfClientID == "" {
+		p.OnBehalfOfClientID, _ = lookupEnv("AZURE_CLIENT_ID")
+	}
+	if p.OnBehalfOfTenantID == "" {
+		p.OnBehalfOfTenantID, _ = lookupEnv("AZURE_TENANT_ID")
+	}
+	if p.oboSecret == "" {
+		p.oboSecret, _ = lookupEnv("AZURE_CLIENT_SECRET")
+	}
+	p.ClientAssertionClientID, p.ClientAssertionTenantID = options.ClientAssertionClientID, options.ClientAssertionTenantID
+	if p.ClientAssertionClientID == "" {
+		p.ClientAssertionClientID, _ = lookupEnv("AZURE_CLIENT_ID")
+	}
+	if p.ClientAssertionTenantID == "" {
+		p.ClientAssertionTenantID, _ = lookupEnv("AZURE_TENANT_ID")
+	}
+	if containsKind(p.Credentials, CredentialKindStaticToken) {
+		tokenVar, expiryVar := options.StaticTokenEnvVar, options.StaticTokenExpiryEnvVar
+		if tokenVar == "" {
+			tokenVar = defaultStaticTokenEnvVar
+		}
+		if expiryVar == "" {
+			expiryVar = defaultStaticTokenExpiryEnvVar
+		}
+		p.staticToken, _ = lookupEnv(tokenVar)
+		p.staticTokenExpiry, _ = lookupEnv(expiryVar)
+	}
+	if options.Cloud.ActiveDirectoryAuthorityHost == "" {
+		p.authorityHost, _ = lookupEnv("AZURE_AUTHORITY_HOST")
+	}
+	p.AzureCLITenantID = options.TenantID
+	p.applyCredentialIdentity()
+	p.ManagedIdentitySource = detectManagedIdentitySource(lookupEnv)
+	switch p.ManagedIdentitySource {
+	case ManagedIdentitySourceCloudShell:
+		p.miDetectedEndpoint, _ = lookupEnv(envMSIEndpoint)
+	case ManagedIdentitySourceIMDS:
+	default:
+		p.miDetectedEndpoint, _ = lookupEnv(envIdentityEndpoint)
+	}
+	if t := options.ManagedIdentityTransport; t != "" && t != p.ManagedIdentitySource {
+		p.ManagedIdentitySource = t
+		p.miOverridden = true
+		switch t {
+		case ManagedIdentitySourceAppService, ManagedIdentitySourceContainerApps:
+			p.miEndpoint, _ = lookupEnv(envIdentityEndpoint)
+			p.miHeader, _ = lookupEnv(envIdentityHeader)
+		case ManagedIdentitySourceAzureArc:
+			p.miEndpoint, _ = lookupEnv(envIdentityEndpoint)
+		}
+	} else {
+		p.miErr = validateManagedIdentityEnv(lookupEnv)
+	}
+	if e := options.ManagedIdentityEndpoint; e != "" {
+		p.ManagedIdentitySource = ManagedIdentitySourceIMDS
+		if t := options.ManagedIdentityTransport; t != "" {
+			p.ManagedIdentitySource = t
+		}
+		p.miOverridden, p.miEndpoint, p.miErr = true, e, nil
+		if p.ManagedIdentitySource == ManagedIdentitySourceAppService || p.ManagedIdentitySource == ManagedIdentitySourceContainerApps {
+			p.miHeader, _ = lookupEnv(envIdentityHeader)
+		}
+	}
+	return p
+}
+
+// ResolveChainPlan is NewPlan reading the environment variables from env rather than from the process environment,
+// e.g. for linting a configuration without building any credential. It takes precedence over options.EnvLookup.
+func ResolveChainPlan(options *DefaultAzureCredentialOptions, env map[string]string) *Plan {
+	var o DefaultAzureCredentialOptions
+	if options != nil {
+		o = *options
+	}
+	o.EnvLookup = func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	}
+	return NewPlan(&o)
+}
+
+// additionallyAllowedTenants returns the additionally allowed tenants of a credential.
+func (p *Plan) additionallyAllowedTenants(kind CredentialKind) []string {
+	if tenants, ok := p.options.AdditionallyAllowedTenantsByCredential[kind]; ok {
+		return tenants
+	}
+	return p.AdditionallyAllowedTenants
+}
+
+// applyCredentialIdentity overrides the IDs of the credentials listed by the CredentialIdentity option.
+func (p *Plan) applyCredentialIdentity() {
+	override := func(v *string, id string) {
+		if id != "" {
+			*v = id
+		}
+	}
+	for kind, id := range p.options.CredentialIdentity {
+		switch kind {
+		case CredentialKindOnBehalfOf:
+			override(&p.OnBehalfOfClientID, id.ClientID)
+			override(&p.OnBehalfOfTenantID, id.TenantID)
+		case CredentialKindWorkloadIdentity:
+			override(&p.WorkloadIdentityClientID, id.ClientID)
+			override(&p.WorkloadIdentityTenantID, id.TenantID)
+		case CredentialKindClientAssertion:
+			override(&p.ClientAssertionClientID, id.ClientID)
+			override(&p.ClientAssertionTenantID, id.TenantID)
+		case CredentialKindManagedIdentity:
+			override(&p.ManagedIdentityClientID, id.ClientID)
+		case CredentialKindAzureCLI:
+			override(&p.AzureCLITenantID, id.TenantID)
+		default:
+			p.options.logf("CredentialIdentity: ignoring the IDs of %s, which can't be overridden", kind)
+		}
+	}
+}
+
+// disabledReason returns why the credential of kind is disabled by the options, or an empty string if it isn't.
+func (p *Plan) disabledReason(kind CredentialKind) string {
+	o := &p.options
+	switch {
+	case kind == CredentialKindStaticToken && !o.EnableStaticTokenCred:
+		return "opt-in, EnableStaticTokenCred isn't set"
+	case kind == CredentialKindOnBehalfOf && !o.EnableOnBehalfOfCred:
+		return "opt-in, EnableOnBehalfOfCred isn't set"
+	case kind == CredentialKindBroker && o.BrokerEndpoint == "":
+		return "opt-in, BrokerEndpoint isn't set"
+	case kind == CredentialKindEnvironment && o.DisableEnvironmentCred:
+		return "disabled by DisableEnvironmentCred"
+	case kind == CredentialKindWorkloadIdentity && o.DisableWorkloadIdentityCred:
+		return "disabled by DisableWorkloadIdentityCred"
+	case kind == CredentialKindClientAssertion && !o.EnableClientAssertionCred && !o.EnableExperimentalCredentials:
+		return "opt-in, neither EnableClientAssertionCred nor EnableExperimentalCredentials is set"
+	case kind == CredentialKindManagedIdentity && o.DisableManagedIdentityCred:
+		return "disabled by DisableManagedIdentityCred"
+	case kind == CredentialKindAzureCLI && o.DisableAzureCLICred:
+		return "disabled by DisableAzureCLICred"
+	}
+	return ""
+}
+
+// targetScopesReason returns why kind can't serve the TargetScopes, according to its CredentialMeta and the
+// ScopePolicy, or "" if it may.
+func targetScopesReason(kind CredentialKind, options *DefaultAzureCredentialOptions) string {
+	if m, ok := credentialMeta(kind); ok && m.MaxScopes > 0 && len(options.TargetScopes) > m.MaxScopes {
+		return fmt.Sprintf("it can't serve the %d TargetScopes in a single request, at most %d", len(options.TargetScopes), m.MaxScopes)
+	}
+	if allowed, ok := options.ScopePolicy[kind]; ok {
+		for _, scope := range options.TargetScopes {
+			if !scopeAllowed(allowed, scope) {
+				return fmt.Sprintf("the TargetScope %q isn't allowed by the ScopePolicy", scope)
+			}
+		}
+	}
+	return ""
+}
+
+// Build creates the DefaultAzureCredential described by the plan.
+// Some credentials builder function might return error, which will be returned in the `credErrors`,
+// in which case that failed credential will not be included as part of the returned `cred`.
+// If all the possible creds are all failed to build, or if any of the credentials listed in
+// DefaultAzureCredentialOptions.FailOnConstructionError failed to build, non nil `err` will be returned.
+//
+// The `credErrors` are always in the order of Plan.Credentials, which is the order the chain attempts the
+// credentials in, regardless of which credentials are successfully built. Each of them is prefixed by the
+// CredentialKind of the failed credential.
+func (p *Plan) Build() (cred *DefaultAzureCredential, credErrors []error, err error) {
+	if m := p.options.TimeoutMultiplier; m < 0 || math.IsNaN(m) || math.IsInf(m, 0) {
+		return nil, nil, fmt.Errorf("TimeoutMultiplier must be positive, got %v", m)
+	}
+	if p.options.ValidateTenantIDs {
+		if err := p.validateTenantIDs(); err != nil {
+			return nil, nil, err
+		}
+	}
+	metrics := p.options.Metrics
+	if metrics == nil {
+		metrics = NopMetricsSink{}
+	}
+	var wrapped []*wrappedCredential
+	wrap := func(kind CredentialKind, name string, c azcore.TokenCredential) {
+		wrapped = append(wrapped, &wrappedCredential{
+			kind:          kind,
+			name:          name,
+			cred:          c,
+			allowedScopes: p.options.ScopePolicy[kind],
+			metrics:       metrics,
+			logf:          p.options.logf,
+			logger:        p.options.Logger,
+			onFallback:    p.options.OnFallback,
+			onInteractive: p.options.OnInteractiveFallback,
+		})
+	}
+	skipped := map[string]string{}
+	for kind, reason := range p.skipped {
+		name := string(kind)
+		if alias, ok := p.options.CredentialAliases[kind]; ok {
+			name = alias
+		}
+		skipped[name] = reason
+	}
+	clientOptions := p.clientOptions()
+	if len(p.options.ExtraHeaders) != 0 {
+		headers, err := newExtraHeadersPolicy(p.options.ExtraHeaders, p.options.BrokerSecretHeader)
+		if err != nil {
+			return nil, nil, err
+		}
+		clientOptions.PerCallPolicies = append(slices.Clone(clientOptions.PerCallPolicies), headers)
+	}
+	if p.options.MaxChainAttempts > 0 {
+		clientOptions.PerRetryPolicies = append(slices.Clone(clientOptions.PerRetryPolicies), chainAttemptsPolicy{})
+	}
+	if p.options.VerifyClockSkew {
+		clientOptions.PerRetryPolicies = append(slices.Clone(clientOptions.PerRetryPolicies), clockSkewPolicy{})
+	}
+	for i, kind := range p.Credentials {
+		name := string(kind)
+		if alias, ok := p.options.CredentialAliases[kind]; ok {
+			name = alias
+		}
+		if kind == CredentialKindManagedIdentity && len(p.options.ManagedIdentityClientIDs) != 0 && !p.options.NoNetwork {
+			creds, errs := p.newManagedIdentityCredentials(name, clientOptions)
+			for _, err := range errs {
+				credErrors = append(credErrors, err)
+				metrics.ConstructionFailure(name, err)
+				if containsKind(p.options.FailOnConstructionError, kind) {
+					return nil, credErrors, err
+				}
+			}
+			for _, nc := range creds {
+				wrap(kind, nc.Alias, nc.Credential)
+			}
+			if len(creds) == 0 {
+				skipped[name] = "failed to build: " + errors.Join(errs...).Error()
+			}
+			continue
+		}
+		var (
+			c   azcore.TokenCredential
+			err error
+		)
+		if p.options.NoNetwork {
+			c = &stubCredential{name: string(kind), available: i == len(p.Credentials)-1}
+		} else {
+			var co azcore.ClientOptions
+			if co, err = p.credentialClientOptions(kind, clientOptions); err == nil {
+				c, err = p.newCredential(kind, co)
+			}
+		}
+		if err != nil {
+			metrics.ConstructionFailure(name, err)
+			skipped[name] = "failed to build: " + redact(err.Error())
+			err = fmt.Errorf("%s: %w", kind, &redactedError{err: err})
+			credErrors = append(credErrors, err)
+			if containsKind(p.options.FailOnConstructionError, kind) {
+				return nil, credErrors, err
+			}
+			continue
+		}
+		wrap(kind, name, c)
+	}
+	for _, nc := range p.options.Credentials {
+		if nc.Alias == "" || nc.Credential == nil {
+			return nil, credErrors, errors.New("injected credentials require both an Alias and a Credential")
+		}
+		wrap("", nc.Alias, nc.Credential)
+		wrapped[len(wrapped)-1].interactive = nc.Interactive
+	}
+
+	names := map[string]bool{}
+	creds := make([]azcore.TokenCredential, 0, len(wrapped))
+	for _, w := range wrapped {
+		if names[w.name] {
+			return nil, credErrors, fmt.Errorf("the chain has several credentials named %q, give them distinct aliases", w.name)
+		}
+		names[w.name] = true
+		creds = append(creds, w)
+	}
+
+	if len(creds) == 0 {
+		format := p.options.ErrorFormatter
+		if format == nil {
+			format = formatConstructionErrors
+		}
+		return nil, credErrors, errors.New(format(credErrors))
+	}
+
+	chain, err := azidentity.NewChainedTokenCredential(creds, p.chainOptions())
+	if err != nil {
+		return nil, credErrors, err
+	}
+	cred = &DefaultAzureCredential{
+		options: p.options,
+		metrics: metrics,
+		now:     p.now,
+	}
+	if p.options.TokenCache {
+		cred.cache = newTokenCache()
+	}
+	if p.options.SharedTokenCacheFile != "" {
+		cred.shared = &sharedTokenCache{
+			file:        p.options.SharedTokenCacheFile,
+			lockTimeout: p.options.scaleTimeout(sharedTokenCacheLockTimeout),
+			logf:        p.options.logf,
+		}
+	}
+	if cred.cache != nil || cred.shared != nil {
+		cred.refreshSkew = p.options.RefreshSkew
+		if cred.refreshSkew == 0 {
+			cred.refreshSkew = defaultRefreshSkew
+		}
+	}
+	if p.options.CoalesceRequests {
+		cred.flights = newFlightGroup()
+	}
+	cred.state.Store(&chainState{
+		chain:       chain,
+		credentials: wrapped,
+		plan:        p,
+		fingerprint: p.clientFingerprint(),
+		hint:        newCredentialHint(p.options.CredentialHintFile, wrapped, p.options.logf),
+		obo:         containsKind(p.Credentials, CredentialKindOnBehalfOf),
+		skipped:     skipped,
+	})
+	return cred, credErrors, nil
+}
+
+// credentialClientOptions returns the ClientOptions of a credential, with the authority host set by the
+// AuthorityHostFor option, and the dialer set by the ManagedIdentityDialContext option.
+func (p *Plan) credentialClientOptions(kind CredentialKind, co azcore.ClientOptions) (azcore.ClientOptions, error) {
+	if kind == CredentialKindManagedIdentity && p.options.ManagedIdentityDialContext != nil {
+		var err error
+		if co, err = withDialContext(co, p.options.ManagedIdentityDialContext); err != nil {
+			return co, err
+		}
+	}
+	host, ok := p.options.AuthorityHostFor[kind]
+	if !ok {
+		return co, nil
+	}
+	switch kind {
+	case CredentialKindEnvironment, CredentialKindWorkloadIdentity, CredentialKindOnBehalfOf, CredentialKindClientAssertion:
+	default:
+		p.options.logf("AuthorityHostFor: ignoring the authority host of %s, which doesn't authenticate with Azure AD itself", kind)
+		return co, nil
+	}
+	if u, err := url.Parse(host); err != nil || u.Scheme != "https" && u.Scheme != "http" || u.Host == "" {
+		return co, fmt.Errorf("invalid authority host %q, it must be an absolute URL", host)
+	}
+	co.Cloud.ActiveDirectoryAuthorityHost = host
+	return co, nil
+}
+
+func (p *Plan) newCredential(kind CredentialKind, clientOptions azcore.ClientOptions) (azcore.TokenCredential, error) {
+	options := &p.options
+	switch kind {
+	case CredentialKindStaticToken:
+		return newStaticTokenCredential(p.staticToken, p.staticTokenExpiry, p.now)
+	case CredentialKindOnBehalfOf:
+		if p.OnBehalfOfClientID == "" || p.OnBehalfOfTenantID == "" || p.oboSecret == "" {
+			return nil, errors.New("the client ID, tenant ID and client secret are required, set them in the options or by AZURE_CLIENT_ID, AZURE_TENANT_ID and AZURE_CLIENT_SECRET")
+		}
+		return &onBehalfOfCredential{
+			tenantID: p.OnBehalfOfTenantID,
+			clientID: p.OnBehalfOfClientID,
+			secret:   p.oboSecret,
+			options: azidentity.OnBehalfOfCredentialOptions{
+				AdditionallyAllowedTenants: p.additionallyAllowedTenants(kind),
+				ClientOptions:              clientOptions,
+				DisableInstanceDiscovery:   options.DisableInstanceDiscovery,
+			},
+		}, nil
+	case CredentialKindBroker:
+		return NewBrokerCredential(options.BrokerEndpoint, &BrokerCredentialOptions{
+			ClientOptions: clientOptions,
+			Secret:        options.BrokerSecret,
+			SecretHeader:  options.BrokerSecretHeader,
+		})
+	case CredentialKindEnvironment:
+		if options.PreferCertificateAuth {
+			if cred, err := p.newCertificateEnvironmentCredential(clientOptions); cred != nil || err != nil {
+				return cred, err
+			}
+		}
+		return azidentity.NewEnvironmentCredential(&azidentity.EnvironmentCredentialOptions{
+			ClientOptions:            clientOptions,
+			DisableInstanceDiscovery: options.DisableInstanceDiscovery,
+		})
+	case CredentialKindWorkloadIdentity:
+		// azidentity falls back to reading the process environment for empty values, which would bypass the EnvLookup
+		if options.EnvLookup != nil || options.DisableEnvironmentReads {
+			if p.WorkloadIdentityClientID == "" || p.WorkloadIdentityTenantID == "" || p.WorkloadIdentityTokenFilePath == "" {
+				return nil, errors.New("AZURE_CLIENT_ID, AZURE_TENANT_ID and AZURE_FEDERATED_TOKEN_FILE must all be set")
+			}
+		}
+		// the authority host is still read from AZURE_AUTHORITY_HOST by azidentity, unless set in the ClientOptions.Cloud
+		cred, err := azidentity.NewWorkloadIdentityCredential(&azidentity.WorkloadIdentityCredentialOptions{
+			AdditionallyAllowedTenants: p.additionallyAllowedTenants(kind),
+			ClientID:                   p.WorkloadIdentityClientID,
+			ClientOptions:              clientOptions,
+			DisableInstanceDiscovery:   options.DisableInstanceDiscovery,
+			TenantID:                   p.WorkloadIdentityTenantID,
+			TokenFilePath:              p.WorkloadIdentityTokenFilePath,
+		})
+		if err != nil || p.WorkloadIdentityTokenFilePath == "" {
+			return cred, err
+		}
+		return &federatedTokenFileCredential{
+			cred:    cred,
+			file:    p.WorkloadIdentityTokenFilePath,
+			retries: federatedTokenFileRetries,
+			delay:   options.scaleTimeout(federatedTokenFileRetryDelay),
+		}, nil
+	case CredentialKindClientAssertion:
+		if options.GetClientAssertion == nil {
+			return nil, errors.New("the GetClientAssertion option is required")
+		}
+		if p.ClientAssertionClientID == "" || p.ClientAssertionTenantID == "" {
+			return nil, errors.New("the client ID and tenant ID are required, set them in the options or by AZURE_CLIENT_ID and AZURE_TENANT_ID")
+		}
+		getAssertion := options.GetClientAssertion
+		return azidentity.NewClientAssertionCredential(p.ClientAssertionTenantID, p.ClientAssertionClientID, func(ctx context.Context) (string, error) {
+			assertion, err := getAssertion(ctx)
+			if err != nil {
+				return "", fmt.Errorf("failed to get the client assertion: %w", err)
+			}
+			return assertion, nil
+		}, &azidentity.ClientAssertionCredentialOptions{
+			AdditionallyAllowedTenants: p.additionallyAllowedTenants(kind),
+			ClientOptions:              clientOptions,
+			DisableInstanceDiscovery:   options.DisableInstanceDiscovery,
+		})
+	case CredentialKindManagedIdentity:
+		return p.newManagedIdentityCredential(p.ManagedIdentityClientID, clientOptions)
+	case CredentialKindAzureCLI:
+		var cred azcore.TokenCredential
+		if options.CLIRunner != nil {
+			cred = &runnerCLICredential{run: options.CLIRunner, tenantID: p.AzureCLITenantID, allowedTenants: p.additionallyAllowedTenants(kind)}
+		} else {
+			var err error
+			cred, err = azidentity.NewAzureCLICredential(&azidentity.AzureCLICredentialOptions{
+				AdditionallyAllowedTenants: p.additionallyAllowedTenants(kind),
+				TenantID:                   p.AzureCLITenantID,
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+		timeout := options.CLICommandTimeout
+		if timeout == 0 {
+			timeout = options.scaleTimeout(defaultCLICommandTimeout)
+		}
+		var c azcore.TokenCredential = &cliCredential{kind: kind, cred: cred, timeout: timeout}
+		if options.CLITokenCacheTTL > 0 {
+			skew := options.RefreshSkew
+			if skew == 0 {
+				skew = defaultRefreshSkew
+			}
+			c = newCLICachingCredential(c, options.CLITokenCacheTTL, skew, p.now)
+		}
+		return c, nil
+	}
+	if factory, ok := lookupCredentialFactory(kind); ok {
+		o := *options
+		cred, err := factory(&o)
+		if err == nil && cred == nil {
+			err = errors.New("the registered factory returned a nil credential")
+		}
+		return cred, err
+	}
+	return nil, errors.New("unknown credential kind, it must be registered by RegisterCredentialFactory")
+}
+
+// maxManagedIdentityClientIDs bounds DefaultAzureCredentialOptions.ManagedIdentityClientIDs, as each of them may
+// cost a request to the managed identity endpoint.
+const maxManagedIdentityClientIDs = 8
+
+// newManagedIdentityCredentials creates a ManagedIdentityCredential per ManagedIdentityClientIDs, followed by one for
+// the system-assigned identity if ManagedIdentitySystemAssigned is set. Each is named after name and its client ID,
+// or "system" for the system-assigned identity.
+func (p *Plan) newManagedIdentityCredentials(name string, clientOptions azcore.ClientOptions) (creds []NamedCredential, errs []error) {
+	kind := CredentialKindManagedIdentity
+	ids := p.options.ManagedIdentityClientIDs
+	if len(ids) > maxManagedIdentityClientIDs {
+		return nil, []error{fmt.Errorf("%s: at most %d ManagedIdentityClientIDs are supported, got %d", kind, maxManagedIdentityClientIDs, len(ids))}
+	}
+	if p.options.ManagedIdentitySystemAssigned {
+		ids = append(slices.Clone(ids), "")
+	}
+	co, err := p.credentialClientOptions(kind, clientOptions)
+	if err != nil {
+		return nil, []error{fmt.Errorf("%s: %w", kind, &redactedError{err: err})}
+	}
+	for _, id := range ids {
+		label := id
+		if label == "" {
+			label = "system"
+		}
+		cred, err := p.newManagedIdentityCredential(id, co)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s(%s): %w", kind, label, &redactedError{err: err}))
+			continue
+		}
+		creds = append(creds, NamedCredential{Alias: fmt.Sprintf("%s(%s)", name, label), Credential: cred})
+	}
+	return creds, errs
+}
+
+// chainOptions returns the options of the ChainedTokenCredential of the chain.
+func (p *Plan) chainOptions() *azidentity.ChainedTokenCredentialOptions {
+	var chainOptions azidentity.ChainedTokenCredentialOptions
+	if p.options.ChainOptions != nil {
+		chainOptions = *p.options.ChainOptions
+	}
+	if len(p.options.ScopePolicy) != 0 {
+		chainOptions.RetrySources = true
+	}
+	return &chainOptions
+}
+
+// newManagedIdentityCredential creates a ManagedIdentityCredential for the user-assigned identity with the client ID,
+// or the system-assigned identity for an empty ID.
+func (p *Plan) newManagedIdentityCredential(clientID string, clientOptions azcore.ClientOptions) (azcore.TokenCredential, error) {
+	options := &p.options
+	kind := CredentialKindManagedIdentity
+	var (
+		cred azcore.TokenCredential
+		err  error
+	)
+	switch {
+	case p.miOverridden:
+		options.logf("%s: using the %s environment selected by ManagedIdentityTransport", kind, p.ManagedIdentitySource)
+		if p.ManagedIdentitySource == ManagedIdentitySourceAzureArc && clientID != "" {
+			// ignored as by azidentity, rather than failing, as AZURE_CLIENT_ID may be set for the other credentials
+			options.logf("warning: %s: Azure Arc doesn't support user-assigned identities, using the system-assigned identity instead of %s", kind, clientID)
+		}
+		cred, err = newManagedIdentityClient(p.ManagedIdentitySource, p.miEndpoint, p.miHeader, clientID, &clientOptions)
+	case options.DisableEnvironmentReads:
+		// azidentity detects the managed identity environment from the process environment
+		options.logf("%s: using IMDS, as the environment isn't read", kind)
+		cred, err = newManagedIdentityClient(p.ManagedIdentitySource, p.miEndpoint, p.miHeader, clientID, &clientOptions)
+	default:
+		if p.miErr != nil {
+			return nil, p.miErr
+		}
+		options.logf("%s: detected %s environment", kind, p.ManagedIdentitySource)
+		o := &azidentity.ManagedIdentityCredentialOptions{ClientOptions: clientOptions}
+		if clientID != "" {
+			o.ID = azidentity.ClientID(clientID)
+		}
+		cred, err = azidentity.NewManagedIdentityCredential(o)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if p.ManagedIdentitySource == ManagedIdentitySourceIMDS && options.IMDSUnavailableTTL > 0 {
+		if p.imds == nil {
+			p.imds = &imdsState{}
+		}
+		return &imdsCredential{cred: cred, ttl: options.IMDSUnavailableTTL, now: p.now, state: p.imds}, nil
+	}
+	return cred, nil
+}
+
+func containsKind(kinds []CredentialKind, kind CredentialKind) bool {
+	for _, k := range kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// formatConstructionErrors is the default DefaultAzureCredentialOptions.ErrorFormatter.
+func formatConstructionErrors(errs []error) string {
+	msg := "no credential successfully created"
+	for _, err := range errs {
+		msg += "\n\t" + err.Error()
+	}
+	return msg
+}
@@ -0,0 +1,79 @@
+package azidentityext
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+)
+
+// clientOptions returns the ClientOptions of the network based credentials.
+//
+// When no Transport is set, azcore's default transport already uses the proxy configured by the HTTPS_PROXY,
+// HTTP_PROXY and NO_PROXY environment variables. When RespectProxyEnv is set, a custom *http.Client whose
+// transport is an *http.Transport without proxy is made to use these environment variables too.
+//
+// When DisableEnvironmentReads is set, an unset Cloud defaults to the Azure public cloud, as azidentity otherwise
+// reads it from AZURE_AUTHORITY_HOST.
+func (p *Plan) clientOptions() azcore.ClientOptions {
+	co := p.options.ClientOptions
+	if p.options.DisableEnvironmentReads && co.Cloud.ActiveDirectoryAuthorityHost == "" {
+		co.Cloud = cloud.AzurePublic
+	}
+	if !p.options.RespectProxyEnv || co.Transport == nil {
+		return co
+	}
+	client, ok := co.Transport.(*http.Client)
+	if !ok {
+		p.options.logf("RespectProxyEnv: the custom transport isn't an *http.Client, its proxy configuration is left unchanged")
+		return co
+	}
+	var transport *http.Transport
+	switch t := client.Transport.(type) {
+	case nil:
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+	case *http.Transport:
+		if t.Proxy != nil {
+			return co
+		}
+		transport = t.Clone()
+	default:
+		p.options.logf("RespectProxyEnv: the transport of the custom *http.Client isn't an *http.Transport, its proxy configuration is left unchanged")
+		return co
+	}
+	transport.Proxy = http.ProxyFromEnvironment
+	c := *client
+	c.Transport = transport
+	co.Transport = &c
+	return co
+}
+
+// withDialContext makes the transport of co dial with dial, see
+// DefaultAzureCredentialOptions.ManagedIdentityDialContext. The transport must be the default one, or an
+// *http.Client whose transport is an *http.Transport, which is cloned.
+func withDialContext(co azcore.ClientOptions, dial func(ctx context.Context, network, addr string) (net.Conn, error)) (azcore.ClientOptions, error) {
+	var client http.Client
+	if co.Transport != nil {
+		c, ok := co.Transport.(*http.Client)
+		if !ok {
+			return co, errors.New("ManagedIdentityDialContext requires the custom transport to be an *http.Client")
+		}
+		client = *c
+	}
+	var transport *http.Transport
+	switch t := client.Transport.(type) {
+	case nil:
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+	case *http.Transport:
+		transport = t.Clone()
+	default:
+		return co, errors.New("ManagedIdentityDialContext requires the transport of the custom *http.Client to be an *http.Transport")
+	}
+	transport.DialContext = dial
+	client.Transport = transport
+	co.Transport = &client
+	return co, nil
+}
@@ -0,0 +1,32 @@
+package azidentityext
+
+import "regexp"
+
+const redacted = "[REDACTED]"
+
+var (
+	// jwtPattern matches JSON Web Tokens, which access tokens issued by Azure Active Directory are.
+	jwtPattern = regexp.MustCompile(`eyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)
+	// tokenFieldPattern matches JSON fields carrying a token, e.g. in a response body echoed by an error.
+	tokenFieldPattern = regexp.MustCompile(`(?i)("(?:access_token|refresh_token|id_token|accessToken|token)"\s*:\s*)"[^"]*"`)
+)
+
+// redact masks anything looking like a token in s. It is used wherever an error or a log message produced by this
+// module might include a token, so that tokens never end up in logs.
+func redact(s string) string {
+	s = tokenFieldPattern.ReplaceAllString(s, `$1"`+redacted+`"`)
+	return jwtPattern.ReplaceAllString(s, redacted)
+}
+
+// redactedError redacts the message of err, while still unwrapping to it for errors.Is and errors.As.
+type redactedError struct {
+	err error
+}
+
+func (e *redactedError) Error() string {
+	return redact(e.err.Error())
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
@@ -0,0 +1,43 @@
+package azidentityext
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+)
+
+// CredentialFactory builds a credential of a custom kind, from the options of the DefaultAzureCredential.
+type CredentialFactory func(options *DefaultAzureCredentialOptions) (azcore.TokenCredential, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = map[CredentialKind]CredentialFactory{}
+)
+
+// RegisterCredentialFactory makes a custom kind of credential available, so that it can be listed in
+// DefaultAzureCredentialOptions.CredentialOrder. Custom kinds are never part of the default order.
+//
+// Like database/sql.Register, it is meant to be called from the init function of the package providing the
+// credential, and panics if factory is nil, or if kind is a built-in kind or already registered.
+func RegisterCredentialFactory(kind CredentialKind, factory CredentialFactory) {
+	if factory == nil {
+		panic("azidentityext: RegisterCredentialFactory factory is nil")
+	}
+	if containsKind(defaultCredentialOrder, kind) {
+		panic(fmt.Sprintf("azidentityext: RegisterCredentialFactory called for the built-in kind %s", kind))
+	}
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	if _, ok := factories[kind]; ok {
+		panic(fmt.Sprintf("azidentityext: RegisterCredentialFactory called twice for kind %s", kind))
+	}
+	factories[kind] = factory
+}
+
+func lookupCredentialFactory(kind CredentialKind) (CredentialFactory, bool) {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	factory, ok := factories[kind]
+	return factory, ok
+}
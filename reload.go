@@ -0,0 +1,71 @@
+package azidentityext
+
+import "slices"
+
+// ReloadEnv reads the environment again, via the EnvLookup option when set, and rebuilds the chain if the
+// configuration derived from the environment changed, e.g. for a long-running agent whose AZURE_CLIENT_ID was
+// rotated without a restart. The reloadable configuration is the one exposed by the Plan: the enabled credentials,
+// AZURE_ADDITIONALLY_ALLOWED_TENANTS, AZURE_CLIENT_ID, AZURE_TENANT_ID, AZURE_FEDERATED_TOKEN_FILE, the secret of the
+// on-behalf-of credential, the static token, AZURE_AUTHORITY_HOST and the managed identity environment variables.
+// The variables only read by azidentity itself, e.g. the secret of the EnvironmentCredential, aren't compared,
+// though they are read again when the chain is rebuilt.
+//
+// When the configuration is unchanged, the chain is kept as is, including which credential it selected. Otherwise
+// the new chain replaces it as a whole, and the TokenCache is cleared. The returned values are those of Plan.Build
+// for the new chain, which doesn't replace the current one when err is non nil.
+func (c *DefaultAzureCredential) ReloadEnv() (credErrors []error, err error) {
+	old := c.state.Load()
+	p := NewPlan(&c.options)
+	p.now = old.plan.now
+	if old.plan.sameConfig(p) {
+		return nil, nil
+	}
+	built, credErrors, err := p.Build()
+	if err != nil {
+		return credErrors, err
+	}
+	if !c.state.CompareAndSwap(old, built.state.Load()) {
+		// a concurrent reload won, its chain being as recent as this one
+		return credErrors, nil
+	}
+	if c.cache != nil {
+		c.cache.clear()
+	}
+	c.options.logf("reloaded the chain, as the configuration from the environment changed")
+	return credErrors, nil
+}
+
+// sameConfig tells whether the plans have the same configuration derived from the environment. The fields are
+// listed explicitly, so that the internal state of a plan, e.g. its IMDS reachability, doesn't count as a change.
+func (p *Plan) sameConfig(o *Plan) bool {
+	errString := func(err error) string {
+		if err == nil {
+			return ""
+		}
+		return err.Error()
+	}
+	return slices.Equal(p.Credentials, o.Credentials) &&
+		slices.Equal(p.AdditionallyAllowedTenants, o.AdditionallyAllowedTenants) &&
+		p.ManagedIdentityClientID == o.ManagedIdentityClientID &&
+		p.ManagedIdentitySource == o.ManagedIdentitySource &&
+		p.WorkloadIdentityClientID == o.WorkloadIdentityClientID &&
+		p.WorkloadIdentityTenantID == o.WorkloadIdentityTenantID &&
+		p.WorkloadIdentityTokenFilePath == o.WorkloadIdentityTokenFilePath &&
+		p.OnBehalfOfClientID == o.OnBehalfOfClientID &&
+		p.OnBehalfOfTenantID == o.OnBehalfOfTenantID &&
+		p.ClientAssertionClientID == o.ClientAssertionClientID &&
+		p.ClientAssertionTenantID == o.ClientAssertionTenantID &&
+		p.AzureCLITenantID == o.AzureCLITenantID &&
+		errString(p.miErr) == errString(o.miErr) &&
+		p.miOverridden == o.miOverridden &&
+		p.miEndpoint == o.miEndpoint &&
+		p.miHeader == o.miHeader &&
+		p.miDetectedEndpoint == o.miDetectedEndpoint &&
+		p.oboSecret == o.oboSecret &&
+		p.authorityHost == o.authorityHost &&
+		p.envClientID == o.envClientID &&
+		p.envTenantID == o.envTenantID &&
+		p.envUsername == o.envUsername &&
+		p.staticToken == o.staticToken &&
+		p.staticTokenExpiry == o.staticTokenExpiry
+}
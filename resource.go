@@ -0,0 +1,89 @@
+package azidentityext
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// Resource is an Azure resource GetTokenForResource requests tokens for.
+type Resource string
+
+const (
+	ResourceARM        Resource = "ARM"
+	ResourceGraph      Resource = "Graph"
+	ResourceKeyVault   Resource = "KeyVault"
+	ResourceStorage    Resource = "Storage"
+	ResourceServiceBus Resource = "ServiceBus"
+	ResourceEventHubs  Resource = "EventHubs"
+	ResourceSQL        Resource = "SQL"
+)
+
+// resourceAudiences are the audiences of the resources in the public, China and US government clouds, keyed by
+// the authority host of the cloud, normalized by normalizeAuthorityHost.
+var resourceAudiences = map[string]map[Resource]string{
+	cloud.AzurePublic.ActiveDirectoryAuthorityHost: {
+		ResourceARM:        "https://management.azure.com",
+		ResourceGraph:      "https://graph.microsoft.com",
+		ResourceKeyVault:   "https://vault.azure.net",
+		ResourceStorage:    "https://storage.azure.com",
+		ResourceServiceBus: "https://servicebus.azure.net",
+		ResourceEventHubs:  "https://eventhubs.azure.net",
+		ResourceSQL:        "https://database.windows.net",
+	},
+	cloud.AzureChina.ActiveDirectoryAuthorityHost: {
+		ResourceARM:        "https://management.chinacloudapi.cn",
+		ResourceGraph:      "https://microsoftgraph.chinacloudapi.cn",
+		ResourceKeyVault:   "https://vault.azure.cn",
+		ResourceStorage:    "https://storage.azure.com",
+		ResourceServiceBus: "https://servicebus.azure.net",
+		ResourceEventHubs:  "https://eventhubs.azure.net",
+		ResourceSQL:        "https://database.chinacloudapi.cn",
+	},
+	cloud.AzureGovernment.ActiveDirectoryAuthorityHost: {
+		ResourceARM:        "https://management.usgovcloudapi.net",
+		ResourceGraph:      "https://graph.microsoft.us",
+		ResourceKeyVault:   "https://vault.usgovcloudapi.net",
+		ResourceStorage:    "https://storage.azure.com",
+		ResourceServiceBus: "https://servicebus.azure.net",
+		ResourceEventHubs:  "https://eventhubs.azure.net",
+		ResourceSQL:        "https://database.usgovcloudapi.net",
+	},
+}
+
+// resourceScope returns the scope of the resource in the cloud, the public cloud for a zero value. In other clouds,
+// only ARM is supported, if the cloud configures its audience.
+func resourceScope(cfg cloud.Configuration, r Resource) (string, error) {
+	host := cfg.ActiveDirectoryAuthorityHost
+	if host == "" {
+		host = cloud.AzurePublic.ActiveDirectoryAuthorityHost
+	}
+	if audiences, ok := resourceAudiences[normalizeAuthorityHost(host)]; ok {
+		if aud, ok := audiences[r]; ok {
+			return aud + defaultScopeSuffix, nil
+		}
+		return "", fmt.Errorf("unknown resource %q", r)
+	}
+	if r == ResourceARM {
+		if aud := cfg.Services[cloud.ResourceManager].Audience; aud != "" {
+			return strings.TrimSuffix(aud, "/") + defaultScopeSuffix, nil
+		}
+	}
+	return "", fmt.Errorf("the scope of resource %q isn't known for the cloud of authority host %s", r, host)
+}
+
+// GetTokenForResource is GetToken for the scope of the resource in the cloud of the chain, i.e. the one of the
+// ClientOptions, or else the one of AZURE_AUTHORITY_HOST, e.g. https://vault.azure.cn/.default for
+// ResourceKeyVault in the China cloud. The public, China and US government clouds support all the resources, other
+// clouds only ResourceARM, when their configuration sets the audience of cloud.ResourceManager.
+func (c *DefaultAzureCredential) GetTokenForResource(ctx context.Context, resource Resource) (azcore.AccessToken, error) {
+	scope, err := resourceScope(c.state.Load().plan.effectiveCloud(), resource)
+	if err != nil {
+		return azcore.AccessToken{}, err
+	}
+	return c.GetToken(ctx, policy.TokenRequestOptions{Scopes: []string{scope}})
+}
@@ -0,0 +1,225 @@
+package azidentityext
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+const (
+	// sharedTokenCacheVersion is the version of the format of the shared token cache file.
+	sharedTokenCacheVersion = 1
+	// sharedTokenCacheLockTimeout bounds the wait for the lock of the shared token cache file, and
+	// sharedTokenCacheStaleLock is the age after which a lock is considered left behind by a crashed process.
+	sharedTokenCacheLockTimeout = 2 * time.Second
+	sharedTokenCacheStaleLock   = 10 * time.Second
+	sharedTokenCacheLockPoll    = 10 * time.Millisecond
+)
+
+// errSharedTokenCacheLocked is returned when the lock of the shared token cache file can't be acquired in time.
+var errSharedTokenCacheLocked = errors.New("timed out waiting for the lock of the shared token cache file")
+
+// sharedTokenCache is a token cache file shared by the processes of a host, see
+// DefaultAzureCredentialOptions.SharedTokenCacheFile. The file is replaced as a whole on each write, so it's read
+// without locking, while the writers are serialized by a lock file next to it.
+type sharedTokenCache struct {
+	file        string
+	lockTimeout time.Duration
+	logf        func(format string, a ...any)
+}
+
+// sharedTokenCacheFile is the content of the shared token cache file.
+type sharedTokenCacheFile struct {
+	Version int                              `json:"version"`
+	Tokens  map[string]sharedTokenCacheEntry `json:"tokens"`
+}
+
+type sharedTokenCacheEntry struct {
+	Token     string    `json:"token"`
+	ExpiresOn time.Time `json:"expiresOn"`
+}
+
+// sharedKey returns the key of the file for key, client identifying the configuration of the chain, so that
+// processes configured with different identities don't share tokens.
+func sharedKey(key tokenCacheKey, client string) string {
+	return fmt.Sprintf("%s|%s|cae=%t|nodiscovery=%t|%s", key.scopes, key.tenantID, key.enableCAE, key.disableInstanceDiscovery, client)
+}
+
+func (c *sharedTokenCache) get(key string) (azcore.AccessToken, bool) {
+	f, err := c.read()
+	if err != nil {
+		c.logf("ignoring the shared token cache: %v", err)
+		return azcore.AccessToken{}, false
+	}
+	e, ok := f.Tokens[key]
+	if !ok {
+		return azcore.AccessToken{}, false
+	}
+	return azcore.AccessToken{Token: e.Token, ExpiresOn: e.ExpiresOn}, true
+}
+
+// set stores the token, dropping the expired ones. Failures are only logged, as the token was acquired anyway.
+func (c *sharedTokenCache) set(key string, tk azcore.AccessToken, now time.Time) {
+	if err := c.update(func(f *sharedTokenCacheFile) {
+		for k, e := range f.Tokens {
+			if !now.Before(e.ExpiresOn) {
+				delete(f.Tokens, k)
+			}
+		}
+		f.Tokens[key] = sharedTokenCacheEntry{Token: tk.Token, ExpiresOn: tk.ExpiresOn}
+	}); err != nil {
+		c.logf("failed to write the shared token cache: %v", err)
+	}
+}
+
+func (c *sharedTokenCache) read() (*sharedTokenCacheFile, error) {
+	f := &sharedTokenCacheFile{Version: sharedTokenCacheVersion, Tokens: map[string]sharedTokenCacheEntry{}}
+	b, err := os.ReadFile(c.file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, f); err != nil {
+		return nil, fmt.Errorf("malformed file %s: %v", c.file, err)
+	}
+	if f.Version != sharedTokenCacheVersion {
+		return nil, fmt.Errorf("unsupported version %d of the file %s", f.Version, c.file)
+	}
+	if f.Tokens == nil {
+		f.Tokens = map[string]sharedTokenCacheEntry{}
+	}
+	return f, nil
+}
+
+// update applies fn to the content of the file under the lock, replacing the file atomically.
+func (c *sharedTokenCache) update(fn func(*sharedTokenCacheFile)) error {
+	unlock, err := lockFile(c.file+".lock", c.lockTimeout, sharedTokenCacheStaleLock)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	f, err := c.read()
+	if err != nil {
+		// a malformed file, or one of another version, is replaced
+		f = &sharedTokenCacheFile{Version: sharedTokenCacheVersion, Tokens: map[string]sharedTokenCacheEntry{}}
+	}
+	fn(f)
+	b, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(c.file), filepath.Base(c.file)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	// CreateTemp creates the file with mode 0600, so that the tokens are only readable by the owner
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), c.file)
+}
+
+// lockFile acquires the advisory lock file, waiting up to timeout for another process to release it. A lock older
+// than stale is removed, as left behind by a process that crashed while holding it.
+func lockFile(lock string, timeout, stale time.Duration) (unlock func(), err error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		f, err := os.OpenFile(lock, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(lock) }, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, err
+		}
+		if info, err := os.Stat(lock); err == nil && time.Since(info.ModTime()) > stale {
+			os.Remove(lock)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, errSharedTokenCacheLocked
+		}
+		time.Sleep(sharedTokenCacheLockPoll)
+	}
+}
+
+// clientFingerprint identifies the identities the plan authenticates as, for the keys of the shared token cache. It
+// is computed once by Build, from the values the plan resolved, including the AZURE_CLIENT_ID, AZURE_TENANT_ID and
+// AZURE_USERNAME of the EnvironmentCredential read by EnvLookup. It only covers the credentials whose tokens are
+// shared, see sharedCredential.
+func (p *Plan) clientFingerprint() string {
+	h := sha256.Sum256([]byte(strings.Join([]string{
+		strings.Join(credentialKindNames(p.Credentials), ","),
+		p.options.TenantID,
+		p.ManagedIdentityClientID,
+		strings.Join(p.options.ManagedIdentityClientIDs, ","),
+		p.WorkloadIdentityClientID,
+		p.WorkloadIdentityTenantID,
+		p.OnBehalfOfClientID,
+		p.ClientAssertionClientID,
+		p.ClientAssertionTenantID,
+		p.AzureCLITenantID,
+		p.options.BrokerEndpoint,
+		p.envClientID,
+		p.envTenantID,
+		p.envUsername,
+	}, "\x00")))
+	return hex.EncodeToString(h[:8])
+}
+
+// sharedCredential tells whether the tokens of the credential of kind are written to the shared token cache, i.e.
+// whether its identity is covered by the clientFingerprint. The identity of the AzureCLICredential is the account
+// logged in the CLI of the user running the process, the static token is that of the process environment, and
+// that of the injected credentials and of the kinds registered by RegisterCredentialFactory is unknown, so their
+// tokens aren't shared, nor are those acquired on behalf of a user.
+func sharedCredential(kind CredentialKind) bool {
+	switch kind {
+	case CredentialKindAzureCLI, CredentialKindStaticToken, CredentialKindOnBehalfOf:
+		return false
+	}
+	_, ok := credentialMeta(kind)
+	return ok
+}
+
+// share writes the token acquired by the credential named name to the SharedTokenCacheFile, if its tokens are
+// shared.
+func (c *DefaultAzureCredential) share(ctx context.Context, st *chainState, opts policy.TokenRequestOptions, name string, tk azcore.AccessToken) {
+	if c.shared == nil || hashUserAssertion(ctx) != "" {
+		return
+	}
+	key := c.tokenCacheKey(opts)
+	for _, cred := range st.credentials {
+		if cred.name == name {
+			if sharedCredential(cred.kind) {
+				c.shared.set(sharedKey(key, st.fingerprint), tk, c.now())
+			}
+			return
+		}
+	}
+}
+
+func credentialKindNames(kinds []CredentialKind) []string {
+	names := make([]string, len(kinds))
+	for i, kind := range kinds {
+		names[i] = string(kind)
+	}
+	return names
+}
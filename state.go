@@ -0,0 +1,38 @@
+package azidentityext
+
+// credentialStateVersion is the current CredentialState.Version.
+const credentialStateVersion = 1
+
+// CredentialState is the state of a DefaultAzureCredential that can be handed off to another process, e.g. during a
+// blue/green deployment, so that it doesn't probe the chain again. It is JSON-marshalable, and never holds a token.
+type CredentialState struct {
+	// Version is the version of the state format, which ImportState checks.
+	Version int `json:"version"`
+	// Selected is the name of the credential that provided the last token, empty if none did yet.
+	Selected string `json:"selected,omitempty"`
+}
+
+// ExportState returns the state of the credential.
+func (c *DefaultAzureCredential) ExportState() CredentialState {
+	return CredentialState{Version: credentialStateVersion, Selected: c.LastSuccessfulCredential()}
+}
+
+// ImportState makes the credential attempt the selected credential of the state first, the chain being only attempted
+// once it fails, as for DefaultAzureCredentialOptions.CredentialHintFile. A state of another version, or selecting a
+// credential that isn't part of the chain, is ignored with a warning in the Log.
+func (c *DefaultAzureCredential) ImportState(state CredentialState) {
+	if state.Version != credentialStateVersion {
+		c.options.logf("ignoring the imported credential state, its version %d isn't supported", state.Version)
+		return
+	}
+	if state.Selected == "" {
+		return
+	}
+	hint := c.state.Load().hint
+	cred := hint.credential(state.Selected)
+	if cred == nil {
+		c.options.logf("ignoring the imported credential state, %q isn't a credential of the chain", state.Selected)
+		return
+	}
+	hint.prefer(cred)
+}
@@ -0,0 +1,56 @@
+package azidentityext
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// The default environment variables of the StaticTokenCredential.
+const (
+	defaultStaticTokenEnvVar       = "AZURE_ACCESS_TOKEN"
+	defaultStaticTokenExpiryEnvVar = "AZURE_ACCESS_TOKEN_EXPIRES_ON"
+)
+
+// staticTokenCredential returns an access token read from the environment, regardless of the requested scopes.
+type staticTokenCredential struct {
+	tk  azcore.AccessToken
+	now func() time.Time
+}
+
+// newStaticTokenCredential creates a staticTokenCredential from the values of its environment variables, the expiry
+// being either an RFC 3339 time or a Unix time in seconds.
+func newStaticTokenCredential(token, expiry string, now func() time.Time) (*staticTokenCredential, error) {
+	if token == "" {
+		return nil, errors.New("no access token is set")
+	}
+	if expiry == "" {
+		return nil, errors.New("no expiry of the access token is set")
+	}
+	expiresOn, err := time.Parse(time.RFC3339, expiry)
+	if err != nil {
+		sec, serr := strconv.ParseInt(expiry, 10, 64)
+		if serr != nil {
+			return nil, fmt.Errorf("invalid expiry %q of the access token, it must be an RFC 3339 time or a Unix time in seconds", expiry)
+		}
+		expiresOn = time.Unix(sec, 0)
+	}
+	if !now().Before(expiresOn) {
+		return nil, fmt.Errorf("the access token expired at %s", expiresOn.Format(time.RFC3339))
+	}
+	return &staticTokenCredential{tk: azcore.AccessToken{Token: token, ExpiresOn: expiresOn}, now: now}, nil
+}
+
+func (c *staticTokenCredential) GetToken(context.Context, policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	if !c.now().Before(c.tk.ExpiresOn) {
+		return azcore.AccessToken{}, newCredentialUnavailableError(string(CredentialKindStaticToken), fmt.Sprintf("the access token expired at %s", c.tk.ExpiresOn.Format(time.RFC3339)))
+	}
+	return c.tk, nil
+}
+
+var _ azcore.TokenCredential = (*staticTokenCredential)(nil)
@@ -0,0 +1,24 @@
+package azidentityext
+
+import (
+	"context"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// stubCredential replaces a credential of the chain when DefaultAzureCredentialOptions.NoNetwork is set.
+type stubCredential struct {
+	name      string
+	available bool
+}
+
+func (c *stubCredential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	if !c.available {
+		return azcore.AccessToken{}, newCredentialUnavailableError(c.name, "stubbed by NoNetwork")
+	}
+	return azcore.AccessToken{Token: "stub", ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
+
+var _ azcore.TokenCredential = (*stubCredential)(nil)
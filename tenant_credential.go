@@ -0,0 +1,48 @@
+package azidentityext
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// ForTenant returns a credential requesting the tokens of c from the tenant, unless the TokenRequestOptions.TenantID
+// of a call sets another one. It shares the chain and the TokenCache of c, which it leaves unchanged, so it's cheap
+// to create one per tenant, e.g. per request of a multi-tenant service. The tenant must be allowed by the
+// credentials, see AdditionallyAllowedTenants. An invalid tenant ID fails each GetToken call.
+func (c *DefaultAzureCredential) ForTenant(tenantID string) azcore.TokenCredential {
+	return &tenantCredential{cred: c, tenantID: tenantID}
+}
+
+// tenantCredential is the credential returned by ForTenant.
+type tenantCredential struct {
+	cred     *DefaultAzureCredential
+	tenantID string
+}
+
+func (c *tenantCredential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	if !validTenantID(c.tenantID) {
+		return azcore.AccessToken{}, fmt.Errorf("invalid tenant ID %q, it may only contain alphanumeric characters, periods and hyphens", c.tenantID)
+	}
+	if opts.TenantID == "" {
+		opts.TenantID = c.tenantID
+	}
+	return c.cred.GetToken(ctx, opts)
+}
+
+// validTenantID tells whether tenantID is a valid tenant ID or domain name, following the rules of azidentity.
+func validTenantID(tenantID string) bool {
+	if tenantID == "" {
+		return false
+	}
+	for _, r := range tenantID {
+		if !('0' <= r && r <= '9' || 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || r == '.' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+var _ azcore.TokenCredential = (*tenantCredential)(nil)
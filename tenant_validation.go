@@ -0,0 +1,93 @@
+package azidentityext
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+)
+
+// tenantGUIDPattern matches a tenant ID in the GUID format, e.g. 72f988bf-86f1-41af-91ab-2d7cd011db47.
+var tenantGUIDPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// validateTenantIDs checks the tenant IDs resolved by the plan for the credentials of the chain, see
+// DefaultAzureCredentialOptions.ValidateTenantIDs.
+func (p *Plan) validateTenantIDs() error {
+	tenants := []struct {
+		kind       CredentialKind
+		id, source string
+	}{
+		{CredentialKindAzureCLI, p.AzureCLITenantID, "the AzureCLICredential"},
+		{CredentialKindWorkloadIdentity, p.WorkloadIdentityTenantID, "the WorkloadIdentityCredential"},
+		{CredentialKindOnBehalfOf, p.OnBehalfOfTenantID, "the on-behalf-of credential"},
+		{CredentialKindClientAssertion, p.ClientAssertionTenantID, "the ClientAssertionCredential"},
+	}
+	if p.envTenantIDConsumed() && !strictTenantID(p.envTenantID, false) {
+		return fmt.Errorf("invalid tenant ID %q in AZURE_TENANT_ID, it must be a GUID, %q or %q", p.envTenantID, "organizations", "common")
+	}
+	for _, t := range tenants {
+		if t.id != "" && containsKind(p.Credentials, t.kind) && !strictTenantID(t.id, false) {
+			return fmt.Errorf("invalid tenant ID %q of %s, it must be a GUID, %q or %q", t.id, t.source, "organizations", "common")
+		}
+	}
+	check := func(ids []string, source string) error {
+		for _, id := range ids {
+			if !strictTenantID(id, true) {
+				return fmt.Errorf("invalid tenant ID %q in %s, it must be a GUID, %q, %q or %q", id, source, "organizations", "common", "*")
+			}
+		}
+		return nil
+	}
+	if err := check(p.AdditionallyAllowedTenants, "AZURE_ADDITIONALLY_ALLOWED_TENANTS"); err != nil {
+		return err
+	}
+	kinds := make([]CredentialKind, 0, len(p.options.AdditionallyAllowedTenantsByCredential))
+	for kind := range p.options.AdditionallyAllowedTenantsByCredential {
+		kinds = append(kinds, kind)
+	}
+	slices.Sort(kinds)
+	for _, kind := range kinds {
+		if err := check(p.options.AdditionallyAllowedTenantsByCredential[kind], fmt.Sprintf("the AdditionallyAllowedTenantsByCredential of %s", kind)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// envTenantIDConsumed tells whether a credential of the chain uses AZURE_TENANT_ID, i.e. the EnvironmentCredential,
+// which reads it itself, or a credential whose tenant ID isn't overridden by the options.
+func (p *Plan) envTenantIDConsumed() bool {
+	if p.envTenantID == "" {
+		return false
+	}
+	for _, kind := range p.Credentials {
+		switch kind {
+		case CredentialKindEnvironment:
+			return true
+		case CredentialKindWorkloadIdentity:
+			if p.WorkloadIdentityTenantID == p.envTenantID {
+				return true
+			}
+		case CredentialKindOnBehalfOf:
+			if p.OnBehalfOfTenantID == p.envTenantID {
+				return true
+			}
+		case CredentialKindClientAssertion:
+			if p.ClientAssertionTenantID == p.envTenantID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// strictTenantID tells whether tenantID is a GUID or one of the special tenants, the "*" wildcard being only
+// valid when wildcard is set, i.e. in a list of additionally allowed tenants.
+func strictTenantID(tenantID string, wildcard bool) bool {
+	switch tenantID {
+	case "organizations", "common":
+		return true
+	case "*":
+		return wildcard
+	}
+	return tenantGUIDPattern.MatchString(tenantID)
+}
@@ -0,0 +1,130 @@
+package azidentityext
+
+import (
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// defaultRefreshSkew is the default DefaultAzureCredentialOptions.RefreshSkew.
+const defaultRefreshSkew = 5 * time.Minute
+
+// tokenCacheKey identifies the tokens of a tokenCache.
+type tokenCacheKey struct {
+	// scopes are the sorted requested scopes, separated by spaces
+	scopes    string
+	tenantID  string
+	enableCAE bool
+	// userAssertion is the hash of the user assertion of the on-behalf-of credential, so that a token acquired on
+	// behalf of a user is never returned to another one
+	userAssertion string
+	// disableInstanceDiscovery is the DisableInstanceDiscovery option the token was acquired with, which affects
+	// how the authority and thus the tenant are resolved
+	disableInstanceDiscovery bool
+}
+
+func newTokenCacheKey(opts policy.TokenRequestOptions) tokenCacheKey {
+	scopes := append([]string(nil), opts.Scopes...)
+	sort.Strings(scopes)
+	return tokenCacheKey{scopes: strings.Join(scopes, " "), tenantID: opts.TenantID, enableCAE: opts.EnableCAE}
+}
+
+// tokenCacheKey returns the key of the tokens requested with opts in the cache of c.
+func (c *DefaultAzureCredential) tokenCacheKey(opts policy.TokenRequestOptions) tokenCacheKey {
+	key := newTokenCacheKey(opts)
+	key.disableInstanceDiscovery = c.options.DisableInstanceDiscovery
+	return key
+}
+
+func (k tokenCacheKey) String() string {
+	if k.tenantID == "" {
+		return k.scopes
+	}
+	return k.scopes + " (tenant " + k.tenantID + ")"
+}
+
+// tokenCache is an in-memory cache of access tokens.
+type tokenCache struct {
+	mu      sync.Mutex
+	entries map[tokenCacheKey]azcore.AccessToken
+}
+
+func newTokenCache() *tokenCache {
+	return &tokenCache{entries: map[tokenCacheKey]azcore.AccessToken{}}
+}
+
+func (c *tokenCache) get(key tokenCacheKey) (azcore.AccessToken, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	tk, ok := c.entries[key]
+	return tk, ok
+}
+
+func (c *tokenCache) set(key tokenCacheKey, tk azcore.AccessToken) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = tk
+}
+
+// deleteScopes deletes the tokens of the scopes, whatever their tenant and other request options.
+func (c *tokenCache) deleteScopes(scopes string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.entries {
+		if key.scopes == scopes {
+			delete(c.entries, key)
+		}
+	}
+}
+
+func (c *tokenCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	clear(c.entries)
+}
+
+// TokenExpiry returns the expiry of the cached token for the scopes, requested without a tenant. It never acquires
+// a token, the returned bool telling whether an unexpired token is cached. It requires the TokenCache option.
+func (c *DefaultAzureCredential) TokenExpiry(scopes ...string) (time.Time, bool) {
+	if c.cache == nil {
+		return time.Time{}, false
+	}
+	if normalize := c.options.NormalizeScopes; normalize != nil {
+		scopes = normalize(slices.Clone(scopes))
+	}
+	tk, ok := c.cache.get(c.tokenCacheKey(policy.TokenRequestOptions{Scopes: scopes}))
+	if !ok || !c.now().Before(tk.ExpiresOn) {
+		return time.Time{}, false
+	}
+	return tk.ExpiresOn, true
+}
+
+// NextRefresh returns when GetToken will refresh the cached token for the scopes, requested without a tenant, i.e.
+// its expiry minus the RefreshSkew, which may be in the past when the token is due for a refresh. It never acquires
+// a token, the returned bool telling whether an unexpired token is cached. It requires the TokenCache option.
+func (c *DefaultAzureCredential) NextRefresh(scopes ...string) (time.Time, bool) {
+	expiry, ok := c.TokenExpiry(scopes...)
+	if !ok {
+		return time.Time{}, false
+	}
+	return expiry.Add(-c.refreshSkew), true
+}
+
+// InvalidateToken evicts the cached tokens for the scopes, whatever their tenant, so that the next GetToken for them
+// acquires a fresh token, e.g. when the resource answered 401 to a request made with the cached token, for HTTP
+// clients that don't handle claims challenges. It does nothing when no token is cached for the scopes, or without
+// the TokenCache option.
+func (c *DefaultAzureCredential) InvalidateToken(scopes ...string) {
+	if c.cache == nil {
+		return
+	}
+	if normalize := c.options.NormalizeScopes; normalize != nil {
+		scopes = normalize(slices.Clone(scopes))
+	}
+	c.cache.deleteScopes(newTokenCacheKey(policy.TokenRequestOptions{Scopes: scopes}).scopes)
+}
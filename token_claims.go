@@ -0,0 +1,51 @@
+package azidentityext
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+)
+
+// tokenClaims are the claims of an access token reported by LastTokenClaims.
+type tokenClaims struct {
+	TenantID string `json:"tid"`
+	// AppID is the client ID of the application the token was issued to, in v1.0 tokens.
+	AppID string `json:"appid"`
+	// AuthorizedParty is the AppID of v2.0 tokens.
+	AuthorizedParty string `json:"azp"`
+}
+
+// parseTokenClaims decodes the claims of a JWT, without verifying it. The zero value is returned for a token that
+// isn't a JWT.
+func parseTokenClaims(token string) tokenClaims {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return tokenClaims{}
+	}
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return tokenClaims{}
+	}
+	var claims tokenClaims
+	if err := json.Unmarshal(b, &claims); err != nil {
+		return tokenClaims{}
+	}
+	if claims.AppID == "" {
+		claims.AppID = claims.AuthorizedParty
+	}
+	return claims
+}
+
+// LastTokenClaims returns the tenant ID (tid) and application ID (appid, or azp for v2.0 tokens) claims of the last
+// token acquired by the credentials of the chain, e.g. to log which tenant and application it is for. ok is false
+// until a token is acquired, or when the last token isn't a JWT or lacks both claims.
+//
+// The claims are decoded without verifying the signature of the token, so they are only meant for display and
+// diagnostics, and must never be used for authorization decisions.
+func (c *DefaultAzureCredential) LastTokenClaims() (tid string, appid string, ok bool) {
+	claims, _ := c.lastClaims.Load().(tokenClaims)
+	if claims.TenantID == "" && claims.AppID == "" {
+		return "", "", false
+	}
+	return claims.TenantID, claims.AppID, true
+}
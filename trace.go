@@ -0,0 +1,68 @@
+package azidentityext
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// TraceEventType is the type of a TraceEvent.
+type TraceEventType string
+
+const (
+	TraceEventAttempt TraceEventType = "attempt"
+	TraceEventSuccess TraceEventType = "success"
+	TraceEventFailure TraceEventType = "failure"
+	// TraceEventSkipped is recorded for a credential skipped by the ScopePolicy, instead of an attempt.
+	TraceEventSkipped TraceEventType = "skipped"
+)
+
+// TraceEvent is a token acquisition event of a credential of the chain, recorded by a CredentialTrace.
+type TraceEvent struct {
+	Type       TraceEventType
+	Credential string
+	Time       time.Time
+	// Duration is the duration of the attempt, for success and failure events.
+	Duration time.Duration
+	// Err is the error of failure events, and the reason of skipped events.
+	Err error
+}
+
+// CredentialTrace records the events of the GetToken calls made with the context returned by WithCredentialTrace.
+type CredentialTrace struct {
+	mu     sync.Mutex
+	events []TraceEvent
+}
+
+// WithCredentialTrace returns a context that makes a GetToken called with it record the events of the credentials
+// it attempts in the returned trace, e.g. to attach them to the span or the logs of the request needing the token.
+// A call served from the TokenCache attempts no credential, so it records no event.
+func WithCredentialTrace(ctx context.Context) (context.Context, *CredentialTrace) {
+	t := &CredentialTrace{}
+	return context.WithValue(ctx, credentialTraceKey{}, t), t
+}
+
+type credentialTraceKey struct{}
+
+func credentialTraceFromContext(ctx context.Context) *CredentialTrace {
+	t, _ := ctx.Value(credentialTraceKey{}).(*CredentialTrace)
+	return t
+}
+
+// Events returns the events recorded so far, in order.
+func (t *CredentialTrace) Events() []TraceEvent {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]TraceEvent(nil), t.events...)
+}
+
+// record appends an event to t, which may be nil.
+func (t *CredentialTrace) record(e TraceEvent) {
+	if t == nil {
+		return
+	}
+	e.Time = time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.events = append(t.events, e)
+}
@@ -0,0 +1,74 @@
+package azidentityext
+
+import (
+	"fmt"
+	"strings"
+)
+
+// troubleshootingHint maps an error message fragment to the action fixing it.
+type troubleshootingHint struct {
+	fragment string
+	hint     string
+}
+
+// troubleshootingHints are matched in order against the construction errors, the first match winning.
+var troubleshootingHints = []troubleshootingHint{
+	{"missing environment variable AZURE_TENANT_ID", "set AZURE_TENANT_ID to the tenant of the service principal"},
+	{"missing environment variable AZURE_CLIENT_ID", "set AZURE_CLIENT_ID to the client ID of the service principal"},
+	{"incomplete environment variable configuration", "set AZURE_CLIENT_SECRET, AZURE_CLIENT_CERTIFICATE_PATH, or AZURE_USERNAME and AZURE_PASSWORD"},
+	{"no value for AZURE_PASSWORD", "set AZURE_PASSWORD along with AZURE_USERNAME"},
+	{"failed to read certificate file", "check that AZURE_CLIENT_CERTIFICATE_PATH points to a readable file"},
+	{"failed to load certificate", "check that the certificate is a PEM or PKCS12 file, and set AZURE_CLIENT_CERTIFICATE_PASSWORD if it's encrypted"},
+	{"AZURE_FEDERATED_TOKEN_FILE", "set AZURE_CLIENT_ID, AZURE_TENANT_ID and AZURE_FEDERATED_TOKEN_FILE, typically by enabling workload identity on the pod"},
+	{"Check pod configuration", "set AZURE_CLIENT_ID, AZURE_TENANT_ID and AZURE_FEDERATED_TOKEN_FILE, typically by enabling workload identity on the pod"},
+	{envIdentityEndpoint, "set both IDENTITY_ENDPOINT and IDENTITY_HEADER, or neither of them to use IMDS"},
+	{envMSIEndpoint, "set both MSI_ENDPOINT and MSI_SECRET, or neither of them to use IMDS"},
+	{"AZURE_CLIENT_SECRET", "set AZURE_CLIENT_ID, AZURE_TENANT_ID and AZURE_CLIENT_SECRET, or the OnBehalfOf* options"},
+	{"executable file not found", "install the Azure CLI and make sure az is on the PATH"},
+	{"az login", "run az login"},
+}
+
+// troubleshootingKindHints are the hints of the credential kinds whose error matches no troubleshootingHints.
+var troubleshootingKindHints = map[CredentialKind]string{
+	CredentialKindStaticToken:      "set AZURE_ACCESS_TOKEN and AZURE_ACCESS_TOKEN_EXPIRES_ON to an unexpired token and its RFC 3339 expiry, or disable the static token credential",
+	CredentialKindOnBehalfOf:       "configure the application by the OnBehalfOf* options or by AZURE_CLIENT_ID, AZURE_TENANT_ID and AZURE_CLIENT_SECRET",
+	CredentialKindBroker:           "check the BrokerEndpoint option",
+	CredentialKindEnvironment:      "set AZURE_TENANT_ID, AZURE_CLIENT_ID and the secret, certificate or password of the service principal or user",
+	CredentialKindWorkloadIdentity: "set AZURE_CLIENT_ID, AZURE_TENANT_ID and AZURE_FEDERATED_TOKEN_FILE",
+	CredentialKindClientAssertion:  "set the GetClientAssertion option, and the client ID and tenant ID by the ClientAssertion* options or by AZURE_CLIENT_ID and AZURE_TENANT_ID",
+	CredentialKindManagedIdentity:  "check the managed identity environment variables, or disable the managed identity credential",
+	CredentialKindAzureCLI:         "install the Azure CLI and run az login",
+}
+
+// FormatTroubleshooting turns the construction errors returned by NewDefaultAzureCredential or Plan.Build into a
+// numbered checklist for end users, with one line per error giving the credential, the action to take and the
+// original error. It returns an empty string for no errors.
+func FormatTroubleshooting(credErrors []error) string {
+	var b strings.Builder
+	for i, err := range credErrors {
+		msg := err.Error()
+		name, cause := "", msg
+		if n, c, ok := strings.Cut(msg, ": "); ok && !strings.Contains(n, " ") {
+			name, cause = n, c
+		}
+		hint := troubleshootingHintFor(CredentialKind(name), cause)
+		if name == "" {
+			fmt.Fprintf(&b, "%d. %s (%s)\n", i+1, hint, cause)
+		} else {
+			fmt.Fprintf(&b, "%d. %s: %s (%s)\n", i+1, name, hint, cause)
+		}
+	}
+	return b.String()
+}
+
+func troubleshootingHintFor(kind CredentialKind, cause string) string {
+	for _, h := range troubleshootingHints {
+		if strings.Contains(cause, h.fragment) {
+			return h.hint
+		}
+	}
+	if hint, ok := troubleshootingKindHints[kind]; ok {
+		return hint
+	}
+	return "check the configuration of this credential"
+}
@@ -0,0 +1,9 @@
+package azidentityext
+
+const (
+	// component is the module name used in the user agent of the requests sent by this module's credentials.
+	component = "azidentityext"
+
+	// version is the semantic version (see http://semver.org) of this module.
+	version = "v0.1.0"
+)
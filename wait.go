@@ -0,0 +1,45 @@
+package azidentityext
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// WaitUntilReady requests a token for scopes every interval until one is acquired, e.g. at startup on hosts where
+// the managed identity endpoint becomes available shortly after boot. It returns nil once a token is acquired, and
+// otherwise when ctx is done, an error wrapping both the error of ctx and the last GetToken error. The attempts
+// are passed ctx, so that none outlives its deadline.
+func (c *DefaultAzureCredential) WaitUntilReady(ctx context.Context, interval time.Duration, scopes ...string) error {
+	if interval <= 0 {
+		return errors.New("WaitUntilReady requires a positive interval")
+	}
+	if len(scopes) == 0 {
+		return errors.New("WaitUntilReady requires at least one scope")
+	}
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	var lastErr error
+	for {
+		select {
+		case <-ctx.Done():
+			if lastErr == nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("%w, last error: %w", ctx.Err(), lastErr)
+		case <-timer.C:
+		}
+		_, err := c.GetToken(ctx, policy.TokenRequestOptions{Scopes: scopes})
+		if err == nil {
+			return nil
+		}
+		// once ctx is done, GetToken only returns its error
+		if ctx.Err() == nil {
+			lastErr = err
+		}
+		timer.Reset(interval)
+	}
+}
@@ -0,0 +1,264 @@
+package azidentityext
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// The attribute keys of the slog records emitted for DefaultAzureCredentialOptions.Logger.
+const (
+	slogKeyCredential    = "credential"
+	slogKeyCorrelationID = "correlation_id"
+	slogKeyFrom          = "from"
+	slogKeyDuration      = "duration"
+	slogKeyError         = "error"
+	slogKeyReason        = "reason"
+)
+
+// wrappedCredential wraps each credential of the chain, in order to observe its GetToken calls.
+type wrappedCredential struct {
+	kind CredentialKind
+	name string
+	cred azcore.TokenCredential
+
+	// allowedScopes, if not nil, are the scopes the credential can serve, see DefaultAzureCredentialOptions.ScopePolicy.
+	allowedScopes []string
+
+	metrics    MetricsSink
+	logf       func(format string, a ...any)
+	logger     *slog.Logger
+	onFallback func(from, to string)
+
+	// interactive tells whether the credential prompts a user, onInteractive being called before attempting it
+	interactive   bool
+	onInteractive func(name string)
+}
+
+func (c *wrappedCredential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	a := attemptFromContext(ctx)
+	if a != nil {
+		if !a.take() {
+			// this error isn't a credentialUnavailableError, so that the chain stops
+			err := fmt.Errorf("%s: not attempted: %w", c.name, ErrMaxChainAttempts)
+			a.errs = append(a.errs, err)
+			return azcore.AccessToken{}, err
+		}
+		a.requests.Store(0)
+		a.tokenDate.Store(0)
+		if a.last != "" {
+			c.fallback(ctx, a.last, a.correlationID)
+		}
+		a.last = c.name
+	}
+	tk, err := c.getToken(ctx, opts)
+	if err != nil {
+		err = &redactedError{err: err}
+		if a != nil {
+			a.errs = append(a.errs, err)
+		}
+	} else if a != nil {
+		a.succeeded = c.name
+		a.succeededKind = c.kind
+	}
+	return tk, err
+}
+
+func (c *wrappedCredential) getToken(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	var correlationID string
+	if a := attemptFromContext(ctx); a != nil {
+		correlationID = a.correlationID
+	}
+	trace := credentialTraceFromContext(ctx)
+	if c.allowedScopes != nil {
+		for _, scope := range opts.Scopes {
+			if !scopeAllowed(c.allowedScopes, scope) {
+				c.logf("%s: skipped, scope %q isn't allowed by the ScopePolicy (correlation ID: %s)", c.name, scope, correlationID)
+				if c.logger != nil {
+					c.logger.LogAttrs(ctx, slog.LevelDebug, "credential skipped",
+						slog.String(slogKeyCredential, c.name),
+						slog.String(slogKeyCorrelationID, correlationID),
+						slog.String(slogKeyReason, fmt.Sprintf("scope %q isn't allowed by the ScopePolicy", scope)),
+					)
+				}
+				err := newCredentialUnavailableError(c.name, fmt.Sprintf("scope %q isn't allowed by the ScopePolicy", scope))
+				trace.record(TraceEvent{Type: TraceEventSkipped, Credential: c.name, Err: err})
+				return azcore.AccessToken{}, err
+			}
+		}
+	}
+	if c.interactive {
+		c.logf("warning: %s: attempting an interactive credential, which waits for user interaction (correlation ID: %s)", c.name, correlationID)
+		if c.onInteractive != nil {
+			c.onInteractive(c.name)
+		}
+	}
+	c.logf("%s: attempting to acquire a token (correlation ID: %s)", c.name, correlationID)
+	if c.logger != nil {
+		c.logger.LogAttrs(ctx, slog.LevelDebug, "credential attempt",
+			slog.String(slogKeyCredential, c.name),
+			slog.String(slogKeyCorrelationID, correlationID),
+		)
+	}
+	c.metrics.Attempt(c.name)
+	trace.record(TraceEvent{Type: TraceEventAttempt, Credential: c.name})
+	start := time.Now()
+	tk, err := c.callCredential(ctx, opts)
+	d := time.Since(start)
+	if err != nil {
+		trace.record(TraceEvent{Type: TraceEventFailure, Credential: c.name, Duration: d, Err: &redactedError{err: err}})
+		c.logf("%s: failed to acquire a token in %s: %v (correlation ID: %s)", c.name, d, err, correlationID)
+		if c.logger != nil {
+			c.logger.LogAttrs(ctx, slog.LevelWarn, "credential failure",
+				slog.String(slogKeyCredential, c.name),
+				slog.String(slogKeyCorrelationID, correlationID),
+				slog.Duration(slogKeyDuration, d),
+				slog.String(slogKeyError, redact(err.Error())),
+			)
+		}
+		c.metrics.Failure(c.name, d, err)
+	} else {
+		trace.record(TraceEvent{Type: TraceEventSuccess, Credential: c.name, Duration: d})
+		c.logf("%s: acquired a token in %s (correlation ID: %s)", c.name, d, correlationID)
+		if c.logger != nil {
+			c.logger.LogAttrs(ctx, slog.LevelInfo, "credential success",
+				slog.String(slogKeyCredential, c.name),
+				slog.String(slogKeyCorrelationID, correlationID),
+				slog.Duration(slogKeyDuration, d),
+			)
+		}
+		c.metrics.Success(c.name, d)
+	}
+	return tk, err
+}
+
+// callCredential calls the credential, returning once ctx is done even if the credential doesn't honor it, e.g. the
+// AzureCLICredential of azidentity waiting for a concurrent call to complete, so that the caller's deadline bounds
+// the whole chain. The call then completes in the background, its result being discarded.
+func (c *wrappedCredential) callCredential(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	if ctx.Done() == nil {
+		return c.cred.GetToken(ctx, opts)
+	}
+	type result struct {
+		tk  azcore.AccessToken
+		err error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		tk, err := c.cred.GetToken(ctx, opts)
+		ch <- result{tk, err}
+	}()
+	select {
+	case r := <-ch:
+		return r.tk, r.err
+	case <-ctx.Done():
+		return azcore.AccessToken{}, fmt.Errorf("%s: %w", c.name, ctx.Err())
+	}
+}
+
+// fallback reports that the chain advanced from the credential named from to this one.
+func (c *wrappedCredential) fallback(ctx context.Context, from, correlationID string) {
+	c.logf("%s: falling back from %s (correlation ID: %s)", c.name, from, correlationID)
+	if c.logger != nil {
+		c.logger.LogAttrs(ctx, slog.LevelInfo, "credential fallback",
+			slog.String(slogKeyCredential, c.name),
+			slog.String(slogKeyCorrelationID, correlationID),
+			slog.String(slogKeyFrom, from),
+		)
+	}
+	if c.onFallback != nil {
+		c.onFallback(from, c.name)
+	}
+}
+
+func scopeAllowed(allowed []string, scope string) bool {
+	for _, a := range allowed {
+		if prefix, ok := strings.CutSuffix(a, "*"); ok {
+			if strings.HasPrefix(scope, prefix) {
+				return true
+			}
+		} else if a == scope {
+			return true
+		}
+	}
+	return false
+}
+
+var _ azcore.TokenCredential = (*wrappedCredential)(nil)
+
+// attempt records what happened to the credentials during a single DefaultAzureCredential.GetToken call.
+// The credentials are attempted sequentially within one call, so no locking is needed.
+type attempt struct {
+	correlationID string
+	errs          []error
+	// succeeded is the name of the credential that provided the token
+	succeeded string
+	// last is the name of the last attempted credential
+	last string
+	// succeededKind is the kind of the succeeded credential
+	succeededKind CredentialKind
+
+	// maxAttempts is the MaxChainAttempts option, attempts counting them, and requests the HTTP requests of the
+	// current credential. They are atomic as the requests of a credential may outlive its call, see callCredential.
+	maxAttempts int32
+	attempts    atomic.Int32
+	requests    atomic.Int32
+	// tokenDate is the Date of the last token response of Azure AD to the current credential, in seconds since the
+	// Unix epoch, see clockSkewPolicy
+	tokenDate atomic.Int64
+}
+
+// take counts an attempt, telling whether MaxChainAttempts allows it.
+func (a *attempt) take() bool {
+	n := a.attempts.Add(1)
+	return a.maxAttempts <= 0 || n <= a.maxAttempts
+}
+
+// ErrMaxChainAttempts is wrapped by the error of GetToken when it made the DefaultAzureCredentialOptions.MaxChainAttempts
+// attempts without acquiring a token.
+var ErrMaxChainAttempts = errors.New("the maximum number of attempts of the chain is reached")
+
+// chainAttemptsPolicy counts the HTTP requests of the credentials for MaxChainAttempts, failing those exceeding it.
+// The first request of each credential call is already counted by wrappedCredential.GetToken.
+type chainAttemptsPolicy struct{}
+
+func (chainAttemptsPolicy) Do(req *policy.Request) (*http.Response, error) {
+	if a := attemptFromContext(req.Raw().Context()); a != nil && a.requests.Add(1) > 1 && !a.take() {
+		return nil, maxChainAttemptsError{}
+	}
+	return req.Next()
+}
+
+// maxChainAttemptsError is the error of chainAttemptsPolicy, which the retry policy of azcore doesn't retry, so
+// that reaching MaxChainAttempts doesn't add the backoff of the retries.
+type maxChainAttemptsError struct{}
+
+func (maxChainAttemptsError) Error() string {
+	return ErrMaxChainAttempts.Error()
+}
+
+func (maxChainAttemptsError) Unwrap() error {
+	return ErrMaxChainAttempts
+}
+
+// NonRetriable implements the errorinfo.NonRetriable interface of azcore.
+func (maxChainAttemptsError) NonRetriable() {}
+
+type attemptKey struct{}
+
+func withAttempt(ctx context.Context, a *attempt) context.Context {
+	return context.WithValue(ctx, attemptKey{}, a)
+}
+
+func attemptFromContext(ctx context.Context) *attempt {
+	a, _ := ctx.Value(attemptKey{}).(*attempt)
+	return a
+}